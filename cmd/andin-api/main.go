@@ -1,13 +1,27 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"log"
+	"os"
 
 	"github.com/ubipo/andin-api/internal/api"
 )
 
 func main() {
+	config, err := api.LoadConfig(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration: %v\n", err)
+		os.Exit(2)
+	}
+
 	fmt.Println("Start andin api server")
 
-	api.Serve()
+	if err := api.Serve(config); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -1,38 +1,147 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/graphql-go/handler"
 
+	"github.com/XSAM/otelsql"
 	"github.com/jmoiron/sqlx"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 
 	_ "github.com/lib/pq" // Postgres driver
 )
 
-// Serve serves the andin api over http
-func Serve() {
-	dbpass, exists := os.LookupEnv("DB_PASS")
-	if !exists {
-		panic(fmt.Errorf("must set DB_PASS enviroment variable"))
+// Serve serves the andin api over http until it receives SIGINT or SIGTERM
+func Serve(config Config) error {
+	shutdownTracing, err := setupTracing(context.Background(), config.Tracing)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), config.Server.ShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Printf("error flushing traces: %v", err)
+		}
+	}()
+
+	// Every query gets a span with its statement
+	sqlDB, err := otelsql.Open("postgres", config.DB.connString(),
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{OmitRows: true, OmitConnResetSession: true}))
+	if err != nil {
+		return err
+	}
+	db := sqlx.NewDb(sqlDB, "postgres")
+	defer db.Close()
+	db.SetMaxOpenConns(config.DB.MaxOpenConns)
+	db.SetMaxIdleConns(config.DB.MaxIdleConns)
+	db.SetConnMaxLifetime(config.DB.ConnMaxLifetime)
+
+	if err := waitForDB(context.Background(), db, config.DB.ConnectTimeout); err != nil {
+		return err
+	}
+
+	geocoder, err := newGeocoder(context.Background(), config.Geocoder, db)
+	if err != nil {
+		return err
+	}
+
+	router := newAStarRouter(db, config.Routing.GraphTTL)
+
+	authenticators, err := newAuthenticators(config.Auth)
+	if err != nil {
+		return err
 	}
-	connStr := fmt.Sprintf("user=andin_migrate password=%s dbname=andin_dev sslmode=disable", dbpass)
-	db, err := sqlx.Open("postgres", connStr)
+
+	hub, err := newChangeHub(config.DB)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	defer hub.Close()
 
-	schema := generateSchema(db)
+	limits, err := newCostLimits(config.Limits)
+	if err != nil {
+		return err
+	}
+
+	persisted, err := newPersistedQueries(context.Background(), config.PersistedQueries, db)
+	if err != nil {
+		return err
+	}
+
+	schema := generateSchema(db, geocoder, router)
+	schema.AddExtensions(costExtension{}, metricsExtension{}, tracingExtension{})
 
 	h := handler.New(&handler.Config{
 		Schema:   &schema,
-		Pretty:   true,
-		GraphiQL: true,
+		Pretty:   config.Server.Pretty,
+		GraphiQL: config.Server.GraphiQL,
+		// Every error gets a code, internal errors are only logged
+		FormatErrorFn: formatError,
 	})
+	subscriptions := newSubscriptionHandler(&schema, db, hub, authenticators, limits, persisted)
+	health := &healthHandler{db: db}
+	registry := newMetricsRegistry(db)
+
+	mux := http.NewServeMux()
+	// Subscriptions are upgraded before the metrics and tracing handlers, whose response writers can't be hijacked
+	mux.Handle("/graphql", authHandler(authenticators, config.Auth.AnonymousRole, bodyHandler(int64(config.Server.MaxBodyBytes), subscriptions.handler(tracingHandler("graphql", httpMetricsHandler("graphql", true, persistedQueryHandler(persisted, costHandler(&schema, limits, loaderHandler(db, h)))))))))
+	mux.Handle("/", authHandler(authenticators, config.Auth.AnonymousRole, tracingHandler("ogc", httpMetricsHandler("ogc", false, &ogcHandler{db: db}))))
+	mux.Handle("/tiles/", authHandler(authenticators, config.Auth.AnonymousRole, tracingHandler("tiles", httpMetricsHandler("tiles", false, newTileHandler(db, hub, config.Tiles, config.Auth.AnonymousRole)))))
+	mux.HandleFunc("/healthz", health.serveHealthz)
+	mux.HandleFunc("/readyz", health.serveReadyz)
+
+	server := &http.Server{
+		Addr:    config.Server.ListenAddr,
+		Handler: mux,
+	}
+	server.RegisterOnShutdown(subscriptions.closeAll)
+
+	serveErr := make(chan error, 2)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
 
-	http.Handle("/graphql", h)
-	http.ListenAndServe(":8980", nil)
+	// Metrics aren't authenticated, so they get their own (private) listener instead of the api's
+	var metricsServer *http.Server
+	if config.Server.MetricsAddr != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", metricsHandler(registry))
+		metricsServer = &http.Server{
+			Addr:    config.Server.MetricsAddr,
+			Handler: metricsMux,
+		}
+		go func() {
+			serveErr <- metricsServer.ListenAndServe()
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case err := <-serveErr:
+		return err
+	case sig := <-signals:
+		log.Printf("received %s, draining for up to %s", sig, config.Server.ShutdownTimeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Server.ShutdownTimeout)
+	defer cancel()
+	if metricsServer != nil {
+		metricsServer.Shutdown(ctx)
+	}
+	if err := server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("error draining http server: %v", err)
+	}
+	return nil
 }
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/graphql-go/graphql/gqlerrors"
+	"gopkg.in/yaml.v2"
+)
+
+// Roles a caller can have, every role includes the permissions of the roles before it
+const (
+	roleReader   = "reader"
+	roleSurveyor = "surveyor"
+	roleAdmin    = "admin"
+)
+
+var roleRanks = map[string]int{
+	roleReader:   1,
+	roleSurveyor: 2,
+	roleAdmin:    3,
+}
+
+func isRole(role string) bool {
+	_, exists := roleRanks[role]
+	return exists
+}
+
+// Identity is the caller of a request, Subject is empty for anonymous callers
+type Identity struct {
+	Subject string
+	Roles   []string
+}
+
+func (identity *Identity) authenticated() bool {
+	return identity.Subject != ""
+}
+
+func (identity *Identity) hasRole(role string) bool {
+	for _, r := range identity.Roles {
+		if roleRanks[r] >= roleRanks[role] {
+			return true
+		}
+	}
+	return false
+}
+
+type identityContextKey struct{}
+
+func withIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityContextKey{}, identity)
+}
+
+// identityFromContext returns the caller of the request, an anonymous caller without roles if there is none
+func identityFromContext(ctx context.Context) *Identity {
+	if identity, ok := ctx.Value(identityContextKey{}).(*Identity); ok {
+		return identity
+	}
+	return &Identity{}
+}
+
+// Error codes of authError
+const (
+	errCodeUnauthenticated = "UNAUTHENTICATED"
+	errCodeForbidden       = "FORBIDDEN"
+)
+
+// authError is an error with a code that is reported in the graphql error's extensions
+type authError struct {
+	code    string
+	message string
+}
+
+func (err authError) Error() string {
+	return err.message
+}
+
+func (err authError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": err.code}
+}
+
+// status returns the http status of the error for endpoints other than /graphql
+func (err authError) status() int {
+	if err.code == errCodeUnauthenticated {
+		return http.StatusUnauthorized
+	}
+	return http.StatusForbidden
+}
+
+// requireRole returns an UNAUTHENTICATED or FORBIDDEN error if the caller doesn't have role
+func requireRole(ctx context.Context, role string) error {
+	identity := identityFromContext(ctx)
+	if identity.hasRole(role) {
+		return nil
+	}
+	if !identity.authenticated() {
+		return authError{errCodeUnauthenticated, fmt.Sprintf("must be authenticated as %s", role)}
+	}
+	return authError{errCodeForbidden, fmt.Sprintf("%s is not allowed to do this, must be %s", identity.Subject, role)}
+}
+
+// Authenticator identifies the caller of a request from one kind of credentials
+type Authenticator interface {
+	// Authenticate returns nil (and no error) if the request has no credentials of this kind
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// apiKey is an entry of the api keys file
+type apiKey struct {
+	Key     string   `yaml:"key"`
+	Subject string   `yaml:"subject"`
+	Roles   []string `yaml:"roles"`
+}
+
+// apiKeyAuthenticator authenticates the X-API-Key header against a fixed set of keys
+type apiKeyAuthenticator struct {
+	// Keys by their hash so lookups don't leak timing information about the keys
+	keys map[[sha256.Size]byte]*Identity
+}
+
+func loadAPIKeyAuthenticator(path string) (*apiKeyAuthenticator, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []apiKey
+	if err := yaml.UnmarshalStrict(raw, &keys); err != nil {
+		return nil, fmt.Errorf("invalid api keys file %s: %v", path, err)
+	}
+
+	authenticator := &apiKeyAuthenticator{map[[sha256.Size]byte]*Identity{}}
+	for i, key := range keys {
+		if key.Key == "" || key.Subject == "" {
+			return nil, fmt.Errorf("api key %d in %s must have a key and subject", i, path)
+		}
+		for _, role := range key.Roles {
+			if !isRole(role) {
+				return nil, fmt.Errorf("api key of %s in %s has unknown role \"%s\"", key.Subject, path, role)
+			}
+		}
+		authenticator.keys[sha256.Sum256([]byte(key.Key))] = &Identity{key.Subject, key.Roles}
+	}
+	return authenticator, nil
+}
+
+func (a *apiKeyAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		return nil, nil
+	}
+	identity, exists := a.keys[sha256.Sum256([]byte(key))]
+	if !exists {
+		return nil, fmt.Errorf("invalid api key")
+	}
+	return identity, nil
+}
+
+func newAuthenticators(config AuthConfig) ([]Authenticator, error) {
+	var authenticators []Authenticator
+	if config.APIKeysFile != "" {
+		authenticator, err := loadAPIKeyAuthenticator(config.APIKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, authenticator)
+	}
+	if config.JWTSecret != "" || config.JWKSFile != "" {
+		authenticator, err := newJWTAuthenticator(config)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, authenticator)
+	}
+	return authenticators, nil
+}
+
+// authHandler puts the caller's identity on the request context, callers without credentials get anonymousRole.
+// Requests with invalid credentials are rejected.
+func authHandler(authenticators []Authenticator, anonymousRole string, h http.Handler) http.Handler {
+	anonymous := &Identity{}
+	if anonymousRole != "" {
+		anonymous.Roles = []string{anonymousRole}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity := anonymous
+		for _, authenticator := range authenticators {
+			authenticated, err := authenticator.Authenticate(r)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeErrorResult(w, http.StatusUnauthorized, authError{errCodeUnauthenticated, err.Error()}, nil)
+				return
+			}
+			if authenticated != nil {
+				identity = authenticated
+				break
+			}
+		}
+		h.ServeHTTP(w, r.WithContext(withIdentity(r.Context(), identity)))
+	})
+}
+
+// writeErrorResult responds with a graphql result that only has the error (and extensions)
+func writeErrorResult(w http.ResponseWriter, status int, err gqlerrors.ExtendedError, extensions map[string]interface{}) {
+	result := map[string]interface{}{
+		"errors": []map[string]interface{}{
+			{"message": err.Error(), "extensions": err.Extensions()},
+		},
+	}
+	if extensions != nil {
+		result["extensions"] = extensions
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+// bearerToken returns the token of an Authorization: Bearer header
+func bearerToken(r *http.Request) string {
+	authorization := r.Header.Get("Authorization")
+	const prefix = "bearer "
+	if len(authorization) < len(prefix) || strings.ToLower(authorization[:len(prefix)]) != prefix {
+		return ""
+	}
+	return strings.TrimSpace(authorization[len(prefix):])
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+)
+
+type requestBodyContextKey struct{}
+
+// bodyHandler reads the request body once, up to limit bytes, so the handlers after it that look at the
+// graphql request can share it through requestBody instead of each buffering it again
+func bodyHandler(limit int64, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body []byte
+		if r.Body != nil {
+			var err error
+			body, err = ioutil.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+			if err != nil {
+				// Also a read error other than the limit, the request can't be handled without its body
+				writeErrorResult(w, http.StatusRequestEntityTooLarge, apiError{errCodeInvalidQuery, "", "request body is larger than the limit or couldn't be read"}, nil)
+				return
+			}
+		}
+		h.ServeHTTP(w, withRequestBody(r, body))
+	})
+}
+
+// withRequestBody returns r with body as its (buffered) body
+func withRequestBody(r *http.Request, body []byte) *http.Request {
+	r = r.WithContext(context.WithValue(r.Context(), requestBodyContextKey{}, body))
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	return r
+}
+
+// requestBody returns the body buffered by bodyHandler and rewinds r.Body, so it can be read again
+func requestBody(r *http.Request) []byte {
+	body, _ := r.Context().Value(requestBodyContextKey{}).([]byte)
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body
+}
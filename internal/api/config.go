@@ -0,0 +1,483 @@
+package api
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config holds all settings needed to serve the andin api
+type Config struct {
+	DB               DBConfig               `yaml:"db"`
+	Server           ServerConfig           `yaml:"server"`
+	Geocoder         GeocoderConfig         `yaml:"geocoder"`
+	Routing          RoutingConfig          `yaml:"routing"`
+	Auth             AuthConfig             `yaml:"auth"`
+	Limits           LimitsConfig           `yaml:"limits"`
+	PersistedQueries PersistedQueriesConfig `yaml:"persisted_queries"`
+	Tiles            TilesConfig            `yaml:"tiles"`
+	Tracing          TracingConfig          `yaml:"tracing"`
+}
+
+// DBConfig holds the postgres connection and pool settings
+type DBConfig struct {
+	Host            string        `yaml:"host"`
+	Port            int           `yaml:"port"`
+	User            string        `yaml:"user"`
+	Password        string        `yaml:"password"`
+	Name            string        `yaml:"name"`
+	SSLMode         string        `yaml:"sslmode"`
+	SSLCert         string        `yaml:"sslcert"`
+	SSLKey          string        `yaml:"sslkey"`
+	SSLRootCert     string        `yaml:"sslrootcert"`
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	ConnectTimeout  time.Duration `yaml:"connect_timeout"`
+}
+
+// ServerConfig holds the http server settings
+type ServerConfig struct {
+	ListenAddr      string        `yaml:"listen_addr"`
+	GraphiQL        bool          `yaml:"graphiql"`
+	Pretty          bool          `yaml:"pretty"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	MaxBodyBytes    int           `yaml:"max_body_bytes"`
+	MetricsAddr     string        `yaml:"metrics_addr"`
+}
+
+// GeocoderConfig holds the geocoding providers and their settings
+type GeocoderConfig struct {
+	Providers       []string      `yaml:"providers"`
+	NominatimURL    string        `yaml:"nominatim_url"`
+	PhotonURL       string        `yaml:"photon_url"`
+	PeliasURL       string        `yaml:"pelias_url"`
+	PeliasAPIKey    string        `yaml:"pelias_api_key"`
+	UserAgent       string        `yaml:"user_agent"`
+	Timeout         time.Duration `yaml:"timeout"`
+	BreakerFailures int           `yaml:"breaker_failures"`
+	BreakerCooldown time.Duration `yaml:"breaker_cooldown"`
+	RateLimit       float64       `yaml:"rate_limit"`
+	RateBurst       int           `yaml:"rate_burst"`
+	CacheSize       int           `yaml:"cache_size"`
+	CacheTTL        time.Duration `yaml:"cache_ttl"`
+	NegativeTTL     time.Duration `yaml:"negative_cache_ttl"`
+	PersistentCache bool          `yaml:"persistent_cache"`
+}
+
+// RoutingConfig holds the indoor routing settings
+type RoutingConfig struct {
+	GraphTTL time.Duration `yaml:"graph_ttl"`
+}
+
+// AuthConfig holds the ways callers can authenticate and the role of anonymous callers
+type AuthConfig struct {
+	APIKeysFile   string `yaml:"api_keys_file"`
+	JWTSecret     string `yaml:"jwt_secret"`
+	JWKSFile      string `yaml:"jwks_file"`
+	JWTIssuer     string `yaml:"jwt_issuer"`
+	JWTAudience   string `yaml:"jwt_audience"`
+	RolesClaim    string `yaml:"roles_claim"`
+	AnonymousRole string `yaml:"anonymous_role"`
+}
+
+// LimitsConfig holds the depth and cost budget of a single graphql operation
+type LimitsConfig struct {
+	MaxDepth        int      `yaml:"max_depth"`
+	MaxCost         int      `yaml:"max_cost"`
+	DefaultListSize int      `yaml:"default_list_size"`
+	SpatialCost     int      `yaml:"spatial_cost"`
+	FieldCosts      []string `yaml:"field_costs"`
+}
+
+// PersistedQueriesConfig holds where automatic persisted queries are stored and which may run
+type PersistedQueriesConfig struct {
+	Store        string `yaml:"store"`
+	CacheSize    int    `yaml:"cache_size"`
+	ManifestFile string `yaml:"manifest_file"`
+	Strict       bool   `yaml:"strict"`
+}
+
+// TilesConfig holds the vector tile cache settings
+type TilesConfig struct {
+	CacheSize int           `yaml:"cache_size"`
+	MaxAge    time.Duration `yaml:"max_age"`
+}
+
+// TracingConfig holds where OpenTelemetry traces are exported to, tracing is disabled without an endpoint
+type TracingConfig struct {
+	Endpoint    string  `yaml:"endpoint"`
+	Insecure    bool    `yaml:"insecure"`
+	ServiceName string  `yaml:"service_name"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+// DefaultConfig returns the config used for any key that isn't set elsewhere
+func DefaultConfig() Config {
+	return Config{
+		DB: DBConfig{
+			Host:           "localhost",
+			Port:           5432,
+			User:           "andin_migrate",
+			Name:           "andin_dev",
+			SSLMode:        "disable",
+			MaxIdleConns:   2,
+			ConnectTimeout: time.Minute,
+		},
+		Server: ServerConfig{
+			ListenAddr:      ":8980",
+			GraphiQL:        true,
+			Pretty:          true,
+			ShutdownTimeout: time.Second * 30,
+			MaxBodyBytes:    1 << 20,
+		},
+		Geocoder: GeocoderConfig{
+			Providers:       []string{"nominatim"},
+			NominatimURL:    "https://nominatim.openstreetmap.org",
+			PhotonURL:       "https://photon.komoot.io",
+			UserAgent:       "andin-api",
+			Timeout:         time.Second * 10,
+			BreakerFailures: 3,
+			BreakerCooldown: time.Second * 30,
+			RateLimit:       1,
+			RateBurst:       1,
+			CacheSize:       1000,
+			CacheTTL:        time.Hour * 24 * 7,
+			NegativeTTL:     time.Hour,
+		},
+		Routing: RoutingConfig{
+			GraphTTL: time.Minute * 5,
+		},
+		Auth: AuthConfig{
+			RolesClaim:    "roles",
+			AnonymousRole: roleReader,
+		},
+		Limits: LimitsConfig{
+			MaxDepth:        10,
+			MaxCost:         5000,
+			DefaultListSize: 20,
+			SpatialCost:     10,
+		},
+		PersistedQueries: PersistedQueriesConfig{
+			Store:     "memory",
+			CacheSize: 1000,
+		},
+		Tiles: TilesConfig{
+			CacheSize: 1000,
+			MaxAge:    time.Minute,
+		},
+		Tracing: TracingConfig{
+			ServiceName: "andin-api",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// configKey maps a single config value to its file key, environment variable and flag
+type configKey struct {
+	name  string
+	env   string
+	flag  string
+	usage string
+	field func(config *Config) interface{}
+}
+
+var configKeys = []configKey{
+	{"db.host", "DB_HOST", "db-host", "postgres host", func(c *Config) interface{} { return &c.DB.Host }},
+	{"db.port", "DB_PORT", "db-port", "postgres port", func(c *Config) interface{} { return &c.DB.Port }},
+	{"db.user", "DB_USER", "db-user", "postgres user", func(c *Config) interface{} { return &c.DB.User }},
+	{"db.password", "DB_PASS", "db-pass", "postgres password", func(c *Config) interface{} { return &c.DB.Password }},
+	{"db.name", "DB_NAME", "db-name", "postgres database name", func(c *Config) interface{} { return &c.DB.Name }},
+	{"db.sslmode", "DB_SSLMODE", "db-sslmode", "postgres sslmode (disable, require, verify-ca or verify-full)", func(c *Config) interface{} { return &c.DB.SSLMode }},
+	{"db.sslcert", "DB_SSLCERT", "db-sslcert", "path to the client certificate", func(c *Config) interface{} { return &c.DB.SSLCert }},
+	{"db.sslkey", "DB_SSLKEY", "db-sslkey", "path to the client certificate key", func(c *Config) interface{} { return &c.DB.SSLKey }},
+	{"db.sslrootcert", "DB_SSLROOTCERT", "db-sslrootcert", "path to the root certificate", func(c *Config) interface{} { return &c.DB.SSLRootCert }},
+	{"db.max_open_conns", "DB_MAX_OPEN_CONNS", "db-max-open-conns", "maximum number of open connections (0 is unlimited)", func(c *Config) interface{} { return &c.DB.MaxOpenConns }},
+	{"db.max_idle_conns", "DB_MAX_IDLE_CONNS", "db-max-idle-conns", "maximum number of idle connections", func(c *Config) interface{} { return &c.DB.MaxIdleConns }},
+	{"db.conn_max_lifetime", "DB_CONN_MAX_LIFETIME", "db-conn-max-lifetime", "maximum lifetime of a connection (0 is unlimited)", func(c *Config) interface{} { return &c.DB.ConnMaxLifetime }},
+	{"db.connect_timeout", "DB_CONNECT_TIMEOUT", "db-connect-timeout", "how long to keep retrying the database on startup", func(c *Config) interface{} { return &c.DB.ConnectTimeout }},
+	{"server.listen_addr", "LISTEN_ADDR", "listen-addr", "address to serve http on", func(c *Config) interface{} { return &c.Server.ListenAddr }},
+	{"server.graphiql", "GRAPHIQL", "graphiql", "serve graphiql to browsers", func(c *Config) interface{} { return &c.Server.GraphiQL }},
+	{"server.pretty", "PRETTY", "pretty", "pretty-print json responses", func(c *Config) interface{} { return &c.Server.Pretty }},
+	{"server.shutdown_timeout", "SHUTDOWN_TIMEOUT", "shutdown-timeout", "how long to wait for in-flight requests on shutdown", func(c *Config) interface{} { return &c.Server.ShutdownTimeout }},
+	{"server.max_body_bytes", "MAX_BODY_BYTES", "max-body-bytes", "maximum size of a graphql request body", func(c *Config) interface{} { return &c.Server.MaxBodyBytes }},
+	{"server.metrics_addr", "METRICS_ADDR", "metrics-addr", "address to serve /metrics on (empty disables metrics), keep it private", func(c *Config) interface{} { return &c.Server.MetricsAddr }},
+	{"geocoder.providers", "GEOCODER_PROVIDERS", "geocoder-providers", "comma separated geocoders to try in order (nominatim, photon, pelias, gazetteer)", func(c *Config) interface{} { return &c.Geocoder.Providers }},
+	{"geocoder.nominatim_url", "GEOCODER_NOMINATIM_URL", "geocoder-nominatim-url", "base url of the nominatim api", func(c *Config) interface{} { return &c.Geocoder.NominatimURL }},
+	{"geocoder.photon_url", "GEOCODER_PHOTON_URL", "geocoder-photon-url", "base url of the photon api", func(c *Config) interface{} { return &c.Geocoder.PhotonURL }},
+	{"geocoder.pelias_url", "GEOCODER_PELIAS_URL", "geocoder-pelias-url", "base url of the pelias api", func(c *Config) interface{} { return &c.Geocoder.PeliasURL }},
+	{"geocoder.pelias_api_key", "GEOCODER_PELIAS_API_KEY", "geocoder-pelias-api-key", "pelias api key", func(c *Config) interface{} { return &c.Geocoder.PeliasAPIKey }},
+	{"geocoder.user_agent", "GEOCODER_USER_AGENT", "geocoder-user-agent", "user agent sent to geocoding apis", func(c *Config) interface{} { return &c.Geocoder.UserAgent }},
+	{"geocoder.timeout", "GEOCODER_TIMEOUT", "geocoder-timeout", "timeout per geocoding request", func(c *Config) interface{} { return &c.Geocoder.Timeout }},
+	{"geocoder.breaker_failures", "GEOCODER_BREAKER_FAILURES", "geocoder-breaker-failures", "consecutive failures after which a geocoder is skipped", func(c *Config) interface{} { return &c.Geocoder.BreakerFailures }},
+	{"geocoder.breaker_cooldown", "GEOCODER_BREAKER_COOLDOWN", "geocoder-breaker-cooldown", "how long a failing geocoder is skipped", func(c *Config) interface{} { return &c.Geocoder.BreakerCooldown }},
+	{"geocoder.rate_limit", "GEOCODER_RATE_LIMIT", "geocoder-rate-limit", "maximum requests per second to each online geocoder", func(c *Config) interface{} { return &c.Geocoder.RateLimit }},
+	{"geocoder.rate_burst", "GEOCODER_RATE_BURST", "geocoder-rate-burst", "maximum burst of requests to each online geocoder", func(c *Config) interface{} { return &c.Geocoder.RateBurst }},
+	{"geocoder.cache_size", "GEOCODER_CACHE_SIZE", "geocoder-cache-size", "number of geocoding results kept in memory (0 disables)", func(c *Config) interface{} { return &c.Geocoder.CacheSize }},
+	{"geocoder.cache_ttl", "GEOCODER_CACHE_TTL", "geocoder-cache-ttl", "how long found places are cached", func(c *Config) interface{} { return &c.Geocoder.CacheTTL }},
+	{"geocoder.negative_cache_ttl", "GEOCODER_NEGATIVE_CACHE_TTL", "geocoder-negative-cache-ttl", "how long unknown places are cached", func(c *Config) interface{} { return &c.Geocoder.NegativeTTL }},
+	{"geocoder.persistent_cache", "GEOCODER_PERSISTENT_CACHE", "geocoder-persistent-cache", "also cache geocoding results in the geocode_cache table", func(c *Config) interface{} { return &c.Geocoder.PersistentCache }},
+	{"routing.graph_ttl", "ROUTING_GRAPH_TTL", "routing-graph-ttl", "how long the navigation graph is kept in memory before it is reloaded", func(c *Config) interface{} { return &c.Routing.GraphTTL }},
+	{"auth.api_keys_file", "AUTH_API_KEYS_FILE", "auth-api-keys-file", "path to a yaml file with the accepted api keys", func(c *Config) interface{} { return &c.Auth.APIKeysFile }},
+	{"auth.jwt_secret", "AUTH_JWT_SECRET", "auth-jwt-secret", "shared secret to verify HMAC signed JWTs with", func(c *Config) interface{} { return &c.Auth.JWTSecret }},
+	{"auth.jwks_file", "AUTH_JWKS_FILE", "auth-jwks-file", "path to a JWKS file with the keys to verify RSA and ECDSA signed JWTs with", func(c *Config) interface{} { return &c.Auth.JWKSFile }},
+	{"auth.jwt_issuer", "AUTH_JWT_ISSUER", "auth-jwt-issuer", "required iss claim of JWTs", func(c *Config) interface{} { return &c.Auth.JWTIssuer }},
+	{"auth.jwt_audience", "AUTH_JWT_AUDIENCE", "auth-jwt-audience", "required aud claim of JWTs", func(c *Config) interface{} { return &c.Auth.JWTAudience }},
+	{"auth.roles_claim", "AUTH_ROLES_CLAIM", "auth-roles-claim", "JWT claim that holds the caller's roles", func(c *Config) interface{} { return &c.Auth.RolesClaim }},
+	{"auth.anonymous_role", "AUTH_ANONYMOUS_ROLE", "auth-anonymous-role", "role of unauthenticated callers (reader, surveyor, admin or empty for none)", func(c *Config) interface{} { return &c.Auth.AnonymousRole }},
+	{"limits.max_depth", "LIMITS_MAX_DEPTH", "limits-max-depth", "maximum depth of an operation (0 is unlimited)", func(c *Config) interface{} { return &c.Limits.MaxDepth }},
+	{"limits.max_cost", "LIMITS_MAX_COST", "limits-max-cost", "maximum cost of an operation (0 is unlimited)", func(c *Config) interface{} { return &c.Limits.MaxCost }},
+	{"limits.default_list_size", "LIMITS_DEFAULT_LIST_SIZE", "limits-default-list-size", "assumed size of lists without a first, last or limit argument", func(c *Config) interface{} { return &c.Limits.DefaultListSize }},
+	{"limits.spatial_cost", "LIMITS_SPATIAL_COST", "limits-spatial-cost", "cost of a field that runs a spatial query", func(c *Config) interface{} { return &c.Limits.SpatialCost }},
+	{"limits.field_costs", "LIMITS_FIELD_COSTS", "limits-field-costs", "comma separated Type.field=cost overrides", func(c *Config) interface{} { return &c.Limits.FieldCosts }},
+	{"persisted_queries.store", "PERSISTED_QUERIES_STORE", "persisted-queries-store", "where registered persisted queries are kept (memory or postgres)", func(c *Config) interface{} { return &c.PersistedQueries.Store }},
+	{"persisted_queries.cache_size", "PERSISTED_QUERIES_CACHE_SIZE", "persisted-queries-cache-size", "number of persisted queries kept by the memory store", func(c *Config) interface{} { return &c.PersistedQueries.CacheSize }},
+	{"persisted_queries.manifest_file", "PERSISTED_QUERIES_MANIFEST_FILE", "persisted-queries-manifest-file", "path to a json manifest of pre-registered persisted queries", func(c *Config) interface{} { return &c.PersistedQueries.ManifestFile }},
+	{"persisted_queries.strict", "PERSISTED_QUERIES_STRICT", "persisted-queries-strict", "only allow the queries of the manifest", func(c *Config) interface{} { return &c.PersistedQueries.Strict }},
+	{"tiles.cache_size", "TILES_CACHE_SIZE", "tiles-cache-size", "number of vector tiles kept in memory (0 disables)", func(c *Config) interface{} { return &c.Tiles.CacheSize }},
+	{"tiles.max_age", "TILES_MAX_AGE", "tiles-max-age", "how long clients may cache vector tiles", func(c *Config) interface{} { return &c.Tiles.MaxAge }},
+	{"tracing.endpoint", "TRACING_ENDPOINT", "tracing-endpoint", "host:port of the OTLP/HTTP collector to export traces to (empty disables tracing)", func(c *Config) interface{} { return &c.Tracing.Endpoint }},
+	{"tracing.insecure", "TRACING_INSECURE", "tracing-insecure", "export traces over plain http instead of https", func(c *Config) interface{} { return &c.Tracing.Insecure }},
+	{"tracing.service_name", "TRACING_SERVICE_NAME", "tracing-service-name", "service name of the exported traces", func(c *Config) interface{} { return &c.Tracing.ServiceName }},
+	{"tracing.sample_ratio", "TRACING_SAMPLE_RATIO", "tracing-sample-ratio", "fraction of new traces that are sampled (between 0 and 1)", func(c *Config) interface{} { return &c.Tracing.SampleRatio }},
+}
+
+func (key configKey) describe() string {
+	return fmt.Sprintf("<%s> (env %s, flag -%s)", key.name, key.env, key.flag)
+}
+
+func (key configKey) set(config *Config, raw string) error {
+	var err error
+	switch field := key.field(config).(type) {
+	case *string:
+		*field = raw
+	case *int:
+		*field, err = strconv.Atoi(raw)
+	case *float64:
+		*field, err = strconv.ParseFloat(raw, 64)
+	case *bool:
+		*field, err = strconv.ParseBool(raw)
+	case *time.Duration:
+		*field, err = time.ParseDuration(raw)
+	case *[]string:
+		*field = nil
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*field = append(*field, item)
+			}
+		}
+	default:
+		err = fmt.Errorf("unsupported config type %T", field)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value \"%s\" for %s: %v", raw, key.describe(), err)
+	}
+	return nil
+}
+
+func (key configKey) isBool() bool {
+	_, ok := key.field(&Config{}).(*bool)
+	return ok
+}
+
+// rawFlag collects a flag value as a string so it can be applied after the config file and environment
+type rawFlag struct {
+	value  string
+	isBool bool
+}
+
+func (f *rawFlag) String() string         { return f.value }
+func (f *rawFlag) Set(value string) error { f.value = value; return nil }
+func (f *rawFlag) IsBoolFlag() bool       { return f.isBool }
+
+// LoadConfig builds a Config from (in increasing order of precedence) the defaults,
+// an optional yaml config file, environment variables and command line flags
+func LoadConfig(args []string) (Config, error) {
+	fs := flag.NewFlagSet("andin-api", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a yaml config file (env CONFIG_FILE)")
+	flags := make(map[string]*rawFlag, len(configKeys))
+	for _, key := range configKeys {
+		f := &rawFlag{isBool: key.isBool()}
+		flags[key.flag] = f
+		fs.Var(f, key.flag, fmt.Sprintf("%s (env %s)", key.usage, key.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	config := DefaultConfig()
+
+	if *configFile != "" {
+		content, err := ioutil.ReadFile(*configFile)
+		if err != nil {
+			return config, fmt.Errorf("error reading config file: %v", err)
+		}
+		if err := yaml.UnmarshalStrict(content, &config); err != nil {
+			return config, fmt.Errorf("error parsing config file \"%s\": %v", *configFile, err)
+		}
+	}
+
+	for _, key := range configKeys {
+		if raw, exists := os.LookupEnv(key.env); exists {
+			if err := key.set(&config, raw); err != nil {
+				return config, err
+			}
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, key := range configKeys {
+			if key.flag == f.Name && flagErr == nil {
+				flagErr = key.set(&config, flags[key.flag].value)
+			}
+		}
+	})
+	if flagErr != nil {
+		return config, flagErr
+	}
+
+	return config, config.validate()
+}
+
+func findConfigKey(name string) configKey {
+	for _, key := range configKeys {
+		if key.name == name {
+			return key
+		}
+	}
+	panic(fmt.Errorf("unknown config key %s", name))
+}
+
+func (config *Config) validate() error {
+	required := map[string]string{
+		"db.host":            config.DB.Host,
+		"db.user":            config.DB.User,
+		"db.password":        config.DB.Password,
+		"db.name":            config.DB.Name,
+		"server.listen_addr": config.Server.ListenAddr,
+	}
+	for _, key := range configKeys {
+		if value, isRequired := required[key.name]; isRequired && value == "" {
+			return fmt.Errorf("missing required config %s", key.describe())
+		}
+	}
+
+	if config.Server.MaxBodyBytes < 1 {
+		return fmt.Errorf("%s must be positive (was %d)", findConfigKey("server.max_body_bytes").describe(), config.Server.MaxBodyBytes)
+	}
+	if config.Server.MetricsAddr == config.Server.ListenAddr {
+		return fmt.Errorf("%s must differ from %s, metrics aren't authenticated", findConfigKey("server.metrics_addr").describe(), findConfigKey("server.listen_addr").describe())
+	}
+
+	if config.DB.Port < 1 || config.DB.Port > 65535 {
+		return fmt.Errorf("%s must be between 1 and 65535 (was %d)", findConfigKey("db.port").describe(), config.DB.Port)
+	}
+
+	switch config.DB.SSLMode {
+	case "disable", "require", "verify-ca", "verify-full":
+	default:
+		return fmt.Errorf("%s must be one of disable, require, verify-ca or verify-full (was \"%s\")", findConfigKey("db.sslmode").describe(), config.DB.SSLMode)
+	}
+
+	if (config.DB.SSLCert == "") != (config.DB.SSLKey == "") {
+		return fmt.Errorf("%s and %s must be set together", findConfigKey("db.sslcert").describe(), findConfigKey("db.sslkey").describe())
+	}
+
+	for _, name := range []string{"db.sslcert", "db.sslkey", "db.sslrootcert", "auth.api_keys_file", "auth.jwks_file", "persisted_queries.manifest_file"} {
+		path := *findConfigKey(name).field(config).(*string)
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("invalid %s: %v", findConfigKey(name).describe(), err)
+		}
+	}
+
+	for _, name := range []string{"db.max_open_conns", "db.max_idle_conns", "geocoder.cache_size", "tiles.cache_size", "limits.max_depth", "limits.max_cost", "limits.spatial_cost"} {
+		if *findConfigKey(name).field(config).(*int) < 0 {
+			return fmt.Errorf("%s cannot be negative", findConfigKey(name).describe())
+		}
+	}
+	for _, provider := range config.Geocoder.Providers {
+		switch provider {
+		case "nominatim", "photon", "pelias", "gazetteer":
+		default:
+			return fmt.Errorf("%s contains unknown provider \"%s\"", findConfigKey("geocoder.providers").describe(), provider)
+		}
+	}
+
+	for _, name := range []string{"geocoder.breaker_failures", "geocoder.rate_burst", "limits.default_list_size", "persisted_queries.cache_size"} {
+		if *findConfigKey(name).field(config).(*int) < 1 {
+			return fmt.Errorf("%s must be at least 1", findConfigKey(name).describe())
+		}
+	}
+	if config.Geocoder.RateLimit <= 0 {
+		return fmt.Errorf("%s must be greater than 0", findConfigKey("geocoder.rate_limit").describe())
+	}
+	if config.Tracing.SampleRatio < 0 || config.Tracing.SampleRatio > 1 {
+		return fmt.Errorf("%s must be between 0 and 1 (was %g)", findConfigKey("tracing.sample_ratio").describe(), config.Tracing.SampleRatio)
+	}
+
+	if _, err := newCostLimits(config.Limits); err != nil {
+		return fmt.Errorf("invalid %s: %v", findConfigKey("limits.field_costs").describe(), err)
+	}
+
+	switch config.PersistedQueries.Store {
+	case "memory", "postgres":
+	default:
+		return fmt.Errorf("%s must be one of memory or postgres (was \"%s\")", findConfigKey("persisted_queries.store").describe(), config.PersistedQueries.Store)
+	}
+	if config.PersistedQueries.Strict && config.PersistedQueries.ManifestFile == "" {
+		return fmt.Errorf("%s requires %s", findConfigKey("persisted_queries.strict").describe(), findConfigKey("persisted_queries.manifest_file").describe())
+	}
+
+	if config.Auth.AnonymousRole != "" && !isRole(config.Auth.AnonymousRole) {
+		return fmt.Errorf("%s must be one of reader, surveyor, admin or empty (was \"%s\")", findConfigKey("auth.anonymous_role").describe(), config.Auth.AnonymousRole)
+	}
+	if (config.Auth.JWTSecret != "" || config.Auth.JWKSFile != "") && config.Auth.RolesClaim == "" {
+		return fmt.Errorf("missing required config %s", findConfigKey("auth.roles_claim").describe())
+	}
+
+	for _, name := range []string{"db.conn_max_lifetime", "db.connect_timeout", "server.shutdown_timeout", "geocoder.timeout", "geocoder.breaker_cooldown", "geocoder.cache_ttl", "geocoder.negative_cache_ttl", "routing.graph_ttl", "tiles.max_age"} {
+		if *findConfigKey(name).field(config).(*time.Duration) < 0 {
+			return fmt.Errorf("%s cannot be negative", findConfigKey(name).describe())
+		}
+	}
+
+	return nil
+}
+
+// connString returns the config as a libpq key/value connection string
+func (config *DBConfig) connString() string {
+	params := []struct {
+		key   string
+		value string
+	}{
+		{"host", config.Host},
+		{"port", strconv.Itoa(config.Port)},
+		{"user", config.User},
+		{"password", config.Password},
+		{"dbname", config.Name},
+		{"sslmode", config.SSLMode},
+		{"sslcert", config.SSLCert},
+		{"sslkey", config.SSLKey},
+		{"sslrootcert", config.SSLRootCert},
+	}
+
+	var parts []string
+	for _, param := range params {
+		if param.value == "" {
+			continue
+		}
+		escaped := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(param.value)
+		parts = append(parts, fmt.Sprintf("%s='%s'", param.key, escaped))
+	}
+	return strings.Join(parts, " ")
+}
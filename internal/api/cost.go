@@ -0,0 +1,355 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/handler"
+)
+
+// spatialFields are the fields that run a spatial query, they cost limits.spatial_cost
+var spatialFields = []string{
+	"RootQuery.rooms",
+	"RootQuery.buildings",
+	"RootQuery.roomsConnection",
+	"RootQuery.buildingsConnection",
+	"RootQuery.search",
+	"RootQuery.nearest",
+	"RootQuery.locate",
+	"RootQuery.route",
+	"Room.intersecting",
+}
+
+// listSizeArgs are the arguments that limit the size of a list (or connection)
+var listSizeArgs = []string{"first", "last", "limit", "k"}
+
+// costLimits is the cost model and the budget every operation must stay within, 0 is unlimited
+type costLimits struct {
+	maxDepth        int
+	maxCost         int
+	defaultListSize int
+	// fieldCosts by Type.field, fields returning an object cost 1 and leaf fields 0 by default
+	fieldCosts map[string]int
+}
+
+func newCostLimits(config LimitsConfig) (costLimits, error) {
+	limits := costLimits{
+		maxDepth:        config.MaxDepth,
+		maxCost:         config.MaxCost,
+		defaultListSize: config.DefaultListSize,
+		fieldCosts:      map[string]int{},
+	}
+	for _, field := range spatialFields {
+		limits.fieldCosts[field] = config.SpatialCost
+	}
+	for _, fieldCost := range config.FieldCosts {
+		parts := strings.SplitN(fieldCost, "=", 2)
+		if len(parts) != 2 || !strings.Contains(parts[0], ".") {
+			return limits, fmt.Errorf("invalid field cost \"%s\", must be Type.field=cost", fieldCost)
+		}
+		cost, err := strconv.Atoi(parts[1])
+		if err != nil || cost < 0 {
+			return limits, fmt.Errorf("invalid field cost \"%s\", cost must be a non-negative integer", fieldCost)
+		}
+		limits.fieldCosts[parts[0]] = cost
+	}
+	return limits, nil
+}
+
+// QueryCost is the computed cost and depth of an operation
+type QueryCost struct {
+	Cost     int `json:"cost"`
+	Depth    int `json:"depth"`
+	MaxCost  int `json:"maxCost"`
+	MaxDepth int `json:"maxDepth"`
+}
+
+// costError rejects an operation that is over budget
+type costError struct {
+	cost    QueryCost
+	message string
+}
+
+func (err costError) Error() string {
+	return err.message
+}
+
+func (err costError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": "QUERY_TOO_COMPLEX", "cost": err.cost}
+}
+
+// costAnalysis walks an operation's selections, multiplying the cost of fields nested in lists
+type costAnalysis struct {
+	limits    costLimits
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+	depth     int
+}
+
+// analyze returns the cost of the operation that would be executed, nil if there is none (because
+// the query is invalid, which is reported when executing it)
+func (limits costLimits) analyze(schema *graphql.Schema, query string, operationName string, variables map[string]interface{}) *QueryCost {
+	document, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		return nil
+	}
+
+	analysis := &costAnalysis{
+		limits:    limits,
+		fragments: map[string]*ast.FragmentDefinition{},
+		variables: map[string]interface{}{},
+	}
+	var operation *ast.OperationDefinition
+	for _, definition := range document.Definitions {
+		switch definition := definition.(type) {
+		case *ast.FragmentDefinition:
+			analysis.fragments[definition.Name.Value] = definition
+		case *ast.OperationDefinition:
+			if operationName == "" || (definition.Name != nil && definition.Name.Value == operationName) {
+				operation = definition
+			}
+		}
+	}
+	if operation == nil {
+		return nil
+	}
+
+	for _, definition := range operation.VariableDefinitions {
+		if definition.DefaultValue != nil {
+			analysis.variables[definition.Variable.Name.Value] = definition.DefaultValue.GetValue()
+		}
+	}
+	for name, value := range variables {
+		analysis.variables[name] = value
+	}
+
+	var rootType *graphql.Object
+	switch operation.Operation {
+	case ast.OperationTypeQuery:
+		rootType = schema.QueryType()
+	case ast.OperationTypeMutation:
+		rootType = schema.MutationType()
+	case ast.OperationTypeSubscription:
+		rootType = schema.SubscriptionType()
+	}
+	if rootType == nil {
+		return nil
+	}
+
+	cost := analysis.selectionSet(operation.SelectionSet, rootType, 1, 1, map[string]bool{})
+	return &QueryCost{int(cost), analysis.depth, limits.maxCost, limits.maxDepth}
+}
+
+// check returns a costError if the cost is over budget
+func (limits costLimits) check(cost *QueryCost) error {
+	if cost == nil {
+		return nil
+	}
+	if limits.maxDepth > 0 && cost.Depth > limits.maxDepth {
+		return costError{*cost, fmt.Sprintf("query depth (%d) exceeds the maximum depth (%d)", cost.Depth, limits.maxDepth)}
+	}
+	if limits.maxCost > 0 && cost.Cost > limits.maxCost {
+		return costError{*cost, fmt.Sprintf("query cost (%d) exceeds the maximum cost (%d)", cost.Cost, limits.maxCost)}
+	}
+	return nil
+}
+
+func (analysis *costAnalysis) selectionSet(selectionSet *ast.SelectionSet, parentType graphql.Type, multiplier float64, depth int, spreads map[string]bool) float64 {
+	if selectionSet == nil {
+		return 0
+	}
+	cost := 0.0
+	for _, selection := range selectionSet.Selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			cost += analysis.field(selection, parentType, multiplier, depth, spreads)
+		case *ast.InlineFragment:
+			fragmentType := parentType
+			if selection.TypeCondition != nil {
+				fragmentType = analysis.namedType(selection.TypeCondition.Name.Value, parentType)
+			}
+			cost += analysis.selectionSet(selection.SelectionSet, fragmentType, multiplier, depth, spreads)
+		case *ast.FragmentSpread:
+			name := selection.Name.Value
+			fragment, exists := analysis.fragments[name]
+			// Cycles are rejected when validating
+			if !exists || spreads[name] {
+				continue
+			}
+			spreads[name] = true
+			fragmentType := analysis.namedType(fragment.TypeCondition.Name.Value, parentType)
+			cost += analysis.selectionSet(fragment.SelectionSet, fragmentType, multiplier, depth, spreads)
+			delete(spreads, name)
+		}
+	}
+	return cost
+}
+
+// namedType returns the member of a union with the fragment's type condition name, fallback otherwise
+func (analysis *costAnalysis) namedType(name string, fallback graphql.Type) graphql.Type {
+	if union, isUnion := fallback.(*graphql.Union); isUnion {
+		for _, object := range union.Types() {
+			if object.Name() == name {
+				return object
+			}
+		}
+	}
+	return fallback
+}
+
+func (analysis *costAnalysis) field(field *ast.Field, parentType graphql.Type, multiplier float64, depth int, spreads map[string]bool) float64 {
+	name := field.Name.Value
+	// Introspection is cheap and deeply nested by nature
+	if strings.HasPrefix(name, "__") {
+		return 0
+	}
+	if depth > analysis.depth {
+		analysis.depth = depth
+	}
+
+	var fields graphql.FieldDefinitionMap
+	switch parentType := parentType.(type) {
+	case *graphql.Object:
+		fields = parentType.Fields()
+	case *graphql.Interface:
+		fields = parentType.Fields()
+	}
+	definition, exists := fields[name]
+	if !exists {
+		return 0
+	}
+
+	fieldType := graphql.GetNamed(definition.Type).(graphql.Type)
+	cost, hasCost := analysis.limits.fieldCosts[parentType.Name()+"."+name]
+	if !hasCost && !graphql.IsLeafType(fieldType) {
+		cost = 1
+	}
+
+	childMultiplier := multiplier
+	if size, limited := analysis.listSize(field); limited {
+		childMultiplier *= float64(size)
+	} else if isConnection(fieldType) {
+		childMultiplier *= defaultPageSize
+	} else if _, isList := graphql.GetNullable(definition.Type).(*graphql.List); isList && !isConnectionEdges(parentType, name) {
+		childMultiplier *= float64(analysis.limits.defaultListSize)
+	}
+
+	return multiplier*float64(cost) + analysis.selectionSet(field.SelectionSet, fieldType, childMultiplier, depth+1, spreads)
+}
+
+// isConnection reports whether the field type is a connection, which returns defaultPageSize edges without first or last
+func isConnection(fieldType graphql.Type) bool {
+	return strings.HasSuffix(fieldType.Name(), "Connection")
+}
+
+// isConnectionEdges reports whether the field is the edges of a connection, whose size is set on the connection field
+func isConnectionEdges(parentType graphql.Type, name string) bool {
+	return name == "edges" && strings.HasSuffix(parentType.Name(), "Connection")
+}
+
+// listSize returns the value of the field's first, last, limit or k argument, clamped to [0, maxPageSize] (the
+// largest size any list accepts) so out of range sizes, which the resolver rejects, can't lower the cost
+func (analysis *costAnalysis) listSize(field *ast.Field) (int, bool) {
+	size, limited := analysis.listSizeArg(field)
+	if size < 0 {
+		size = 0
+	}
+	if size > maxPageSize {
+		size = maxPageSize
+	}
+	return size, limited
+}
+
+func (analysis *costAnalysis) listSizeArg(field *ast.Field) (int, bool) {
+	for _, argument := range field.Arguments {
+		isSizeArg := false
+		for _, name := range listSizeArgs {
+			isSizeArg = isSizeArg || argument.Name.Value == name
+		}
+		if !isSizeArg {
+			continue
+		}
+
+		value := argument.Value.GetValue()
+		if variable, isVariable := argument.Value.(*ast.Variable); isVariable {
+			value = analysis.variables[variable.Name.Value]
+		}
+		switch value := value.(type) {
+		case string:
+			if size, err := strconv.Atoi(value); err == nil {
+				return size, true
+			}
+		case float64:
+			return int(value), true
+		case int:
+			return value, true
+		}
+	}
+	return 0, false
+}
+
+type queryCostContextKey struct{}
+
+func withQueryCost(ctx context.Context, cost *QueryCost) context.Context {
+	return context.WithValue(ctx, queryCostContextKey{}, cost)
+}
+
+// costHandler rejects graphql requests that are over budget before they are executed
+func costHandler(schema *graphql.Schema, limits costLimits, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The body is read again by the graphql handler
+		requestBody(r)
+		options := handler.NewRequestOptions(r)
+		requestBody(r)
+
+		cost := limits.analyze(schema, options.Query, options.OperationName, options.Variables)
+		if err := limits.check(cost); err != nil {
+			writeErrorResult(w, http.StatusOK, err.(costError), map[string]interface{}{"cost": cost})
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(withQueryCost(r.Context(), cost)))
+	})
+}
+
+// costExtension reports the cost of every operation in the result's extensions
+type costExtension struct{}
+
+func (costExtension) Init(ctx context.Context, params *graphql.Params) context.Context {
+	return ctx
+}
+
+func (costExtension) Name() string {
+	return "cost"
+}
+
+func (costExtension) ParseDidStart(ctx context.Context) (context.Context, graphql.ParseFinishFunc) {
+	return ctx, func(error) {}
+}
+
+func (costExtension) ValidationDidStart(ctx context.Context) (context.Context, graphql.ValidationFinishFunc) {
+	return ctx, func([]gqlerrors.FormattedError) {}
+}
+
+func (costExtension) ExecutionDidStart(ctx context.Context) (context.Context, graphql.ExecutionFinishFunc) {
+	return ctx, func(*graphql.Result) {}
+}
+
+func (costExtension) ResolveFieldDidStart(ctx context.Context, info *graphql.ResolveInfo) (context.Context, graphql.ResolveFieldFinishFunc) {
+	return ctx, func(interface{}, error) {}
+}
+
+func (costExtension) HasResult() bool {
+	return true
+}
+
+func (costExtension) GetResult(ctx context.Context) interface{} {
+	cost, _ := ctx.Value(queryCostContextKey{}).(*QueryCost)
+	return cost
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"testing"
+)
+
+func TestCostAnalysis(t *testing.T) {
+	schema := generateSchema(nil, nil, nil)
+	limits, err := newCostLimits(LimitsConfig{MaxDepth: 10, MaxCost: 1000, DefaultListSize: 10, SpatialCost: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]interface{}
+		cost      int
+	}{
+		{
+			name:  "connection with first",
+			query: `{ roomsConnection(within: {bbox: [0, 0, 1, 1]}, first: 5) { edges { node { room { building { name } } } } } }`,
+			cost:  10 + 5*(1+1+1+1),
+		},
+		{
+			name:  "connection without first or last costs the default page size",
+			query: `{ roomsConnection(within: {bbox: [0, 0, 1, 1]}) { edges { node { room { building { name } } } } } }`,
+			cost:  10 + defaultPageSize*(1+1+1+1),
+		},
+		{
+			name:  "negative limit costs nothing instead of a negative amount",
+			query: `{ search(query: "x", limit: -100000) { item { ... on Room { building { name } } } } }`,
+			cost:  10,
+		},
+		{
+			name:      "negative variable costs nothing instead of a negative amount",
+			query:     `query($k: Int) { nearest(coordinates: {lon: 0, lat: 0}, k: $k) { item { ... on Room { building { name } } } } }`,
+			variables: map[string]interface{}{"k": -100000.0},
+			cost:      10,
+		},
+		{
+			name:  "size above the maximum is clamped",
+			query: `{ roomsConnection(within: {bbox: [0, 0, 1, 1]}, first: 100000) { edges { node { name } } } }`,
+			cost:  10 + maxPageSize*(1+1),
+		},
+		{
+			name:  "list without a size argument costs the default list size",
+			query: `{ rooms(within: {bbox: [0, 0, 1, 1]}) { room { building { name } } } }`,
+			cost:  10 + 10*(1+1),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cost := limits.analyze(&schema, test.query, "", test.variables)
+			if cost == nil {
+				t.Fatal("no operation analyzed")
+			}
+			if cost.Cost != test.cost {
+				t.Errorf("cost = %d, want %d", cost.Cost, test.cost)
+			}
+		})
+	}
+}
+
+func TestCostCheckNegativeSibling(t *testing.T) {
+	schema := generateSchema(nil, nil, nil)
+	limits, err := newCostLimits(LimitsConfig{MaxDepth: 10, MaxCost: 1000, DefaultListSize: 10, SpatialCost: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := `{
+		search(query: "x", limit: -100000) { item { ... on Room { building { name } } } }
+		roomsConnection(within: {bbox: [0, 0, 1, 1]}, first: 100) {
+			edges { node { room { intersecting { intersecting { name } } } } }
+		}
+	}`
+	cost := limits.analyze(&schema, query, "", nil)
+	if err := limits.check(cost); err == nil {
+		t.Errorf("over budget operation with a negative sibling limit passed with cost %d", cost.Cost)
+	}
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+)
+
+// Error codes of apiError
+const (
+	errCodeNotFound          = "NOT_FOUND"
+	errCodeInvalidArgument   = "INVALID_ARGUMENT"
+	errCodeGeocodeFailed     = "GEOCODE_FAILED"
+	errCodeDataInconsistency = "DATA_INCONSISTENCY"
+	errCodeInternal          = "INTERNAL"
+	// errCodeInvalidQuery is the code of errors in the request itself: syntax, validation and variable errors
+	errCodeInvalidQuery = "INVALID_QUERY"
+)
+
+// apiError is an error with a code and the path of the offending argument (if any, e.g. distanceFrom.place)
+// that are reported in the graphql error's extensions
+type apiError struct {
+	code     string
+	argument string
+	message  string
+}
+
+func (err apiError) Error() string {
+	return err.message
+}
+
+func (err apiError) Extensions() map[string]interface{} {
+	extensions := map[string]interface{}{"code": err.code}
+	if err.argument != "" {
+		extensions["argument"] = err.argument
+	}
+	return extensions
+}
+
+func notFoundError(argument string, format string, a ...interface{}) error {
+	return apiError{errCodeNotFound, argument, fmt.Sprintf(format, a...)}
+}
+
+func invalidArgumentError(argument string, format string, a ...interface{}) error {
+	return apiError{errCodeInvalidArgument, argument, fmt.Sprintf(format, a...)}
+}
+
+func geocodeFailedError(argument string, format string, a ...interface{}) error {
+	return apiError{errCodeGeocodeFailed, argument, fmt.Sprintf(format, a...)}
+}
+
+func dataInconsistencyError(format string, a ...interface{}) error {
+	return apiError{errCodeDataInconsistency, "", fmt.Sprintf(format, a...)}
+}
+
+// inArgument nests the argument of err in argument, for errors about a field of an input object argument
+func inArgument(argument string, err error) error {
+	if err, isAPIError := err.(apiError); isAPIError && err.argument != "" {
+		err.argument = argument + "." + err.argument
+		return err
+	}
+	return err
+}
+
+func newCorrelationID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// formatError gives every graphql error a code. Errors of resolvers without one (database and other
+// unexpected errors) are logged with a correlation id and replaced with a generic message.
+func formatError(err error) gqlerrors.FormattedError {
+	formatted := gqlerrors.FormatError(err)
+	original := err
+	located, isLocated := err.(*gqlerrors.Error)
+	if isLocated {
+		original = located.OriginalError
+	}
+	// Errors of thunks are panicked as formatted errors, which wrap the located error of the resolver
+	for unwrapped := true; unwrapped; {
+		switch wrapper := original.(type) {
+		case gqlerrors.FormattedError:
+			original = wrapper.OriginalError()
+		case *gqlerrors.Error:
+			original = wrapper.OriginalError
+		default:
+			unwrapped = false
+		}
+	}
+	if extended, isExtended := original.(gqlerrors.ExtendedError); isExtended {
+		formatted.Extensions = extended.Extensions()
+		return formatted
+	}
+	// Only resolver errors are located and have an original error
+	if !isLocated || original == nil {
+		formatted.Extensions = map[string]interface{}{"code": errCodeInvalidQuery}
+		return formatted
+	}
+
+	id := newCorrelationID()
+	log.Printf("internal error %s at %v: %v", id, formatted.Path, original)
+	formatted.Message = fmt.Sprintf("internal error, reference %s", id)
+	formatted.Extensions = map[string]interface{}{"code": errCodeInternal, "correlationId": id}
+	return formatted
+}
+
+// formatResultErrors formats the errors of a result that isn't served by the graphql handler
+func formatResultErrors(result *graphql.Result) *graphql.Result {
+	for i, formatted := range result.Errors {
+		if original := formatted.OriginalError(); original != nil {
+			result.Errors[i] = formatError(original)
+		}
+	}
+	return result
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+)
+
+func TestFormatError(t *testing.T) {
+	failing := func(err error) *graphql.Field {
+		return &graphql.Field{
+			Type: graphql.String,
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				return nil, err
+			},
+		}
+	}
+	failingThunk := func(err error) *graphql.Field {
+		return &graphql.Field{
+			Type: graphql.String,
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				return func() (interface{}, error) {
+					return nil, err
+				}, nil
+			},
+		}
+	}
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"notFound":               failing(notFoundError("id", "no room with <id> 1")),
+				"internal":               failing(errors.New("connection refused")),
+				"notFoundThunk":          failingThunk(notFoundError("id", "no room with <id> 1")),
+				"dataInconsistencyThunk": failingThunk(errNoElementWithID(roomConfig)),
+				"internalThunk":          failingThunk(errors.New("connection refused")),
+			},
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		code  string
+	}{
+		{`{ notFound }`, errCodeNotFound},
+		{`{ internal }`, errCodeInternal},
+		{`{ notFoundThunk }`, errCodeNotFound},
+		{`{ dataInconsistencyThunk }`, errCodeDataInconsistency},
+		{`{ internalThunk }`, errCodeInternal},
+		{`{ unknownField }`, errCodeInvalidQuery},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			result := formatResultErrors(graphql.Do(graphql.Params{Schema: schema, RequestString: test.query, Context: context.Background()}))
+			if len(result.Errors) != 1 {
+				t.Fatalf("errors = %v", result.Errors)
+			}
+			if code := result.Errors[0].Extensions["code"]; code != test.code {
+				t.Errorf("code = %v, want %s", code, test.code)
+			}
+		})
+	}
+}
@@ -1,13 +1,24 @@
 package api
 
 type FilteredRoom struct {
-	Distance float32
-	Area     *float32
+	Distance *float64
+	Area     *float64
 	Room
 }
 
 type FilteredBuilding struct {
-	Distance float32
-	Area     *float32
+	Distance *float64
+	Area     *float64
 	Building
 }
+
+type LocatedRoom struct {
+	Contains bool
+	Distance float32
+	Room
+}
+
+type Location struct {
+	Building *Building
+	Rooms    []LocatedRoom
+}
@@ -1,56 +1,147 @@
 package api
 
 import (
-	"fmt"
+	"context"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/mitchellh/mapstructure"
 )
 
 type rootGeographyFilterConfig struct {
-	distanceFrom DistanceFrom
+	distanceFrom optionalDistanceFromFilter
+	within       optionalWithinFilter
 	area         optionalAreaFilter
 	sortChoice   optionalSortChoiceFilter
 }
 
-func parseRootGeographyFilterArgs(args map[string]interface{}) (rootGeographyFilterConfig, error) {
+func parseRootGeographyFilterArgs(ctx context.Context, db *sqlx.DB, geocoder Geocoder, args map[string]interface{}) (rootGeographyFilterConfig, error) {
 	var config rootGeographyFilterConfig
 
-	df := args["distanceFrom"].(map[string]interface{})
+	distanceFromArg := args["distanceFrom"]
+	withinArg := args["within"]
+	if distanceFromArg == nil && withinArg == nil {
+		return config, invalidArgumentError("distanceFrom", "must specify <distanceFrom>, <within> or both")
+	}
+
+	if distanceFromArg != nil {
+		distanceFrom, err := parseDistanceFromArg(ctx, geocoder, distanceFromArg.(map[string]interface{}))
+		if err != nil {
+			return config, err
+		}
+		config.distanceFrom = optionalDistanceFromFilter{true, distanceFrom}
+	}
+
+	if withinArg != nil {
+		within, err := parseWithinArg(ctx, db, withinArg.(map[string]interface{}))
+		if err != nil {
+			return config, err
+		}
+		config.within = optionalWithinFilter{true, within}
+	}
+
+	areaArg := args["area"]
+	var area Area
+	mapstructure.Decode(areaArg, &area)
+	area.Min *= float32(area.Unit.squareMeters())
+	if area.Max != nil {
+		max := *area.Max * float32(area.Unit.squareMeters())
+		area.Max = &max
+	}
+	area.Unit = UnitMeters
+	config.area = optionalAreaFilter{areaArg != nil, area}
+
+	sortArg := args["sort"]
+	var sortChoice SortChoice
+	if sortArg != nil {
+		sortChoice = SortChoice(sortArg.(int))
+	}
+	if sortArg != nil && sortChoice == SortDistance && !config.distanceFrom.use {
+		return config, invalidArgumentError("sort", "cannot sort on DISTANCE without a <distanceFrom> filter")
+	}
+	config.sortChoice = optionalSortChoiceFilter{sortArg != nil, sortChoice}
+
+	return config, nil
+}
+
+// parseDistanceFromArg parses a DistanceFrom filter in meters, geocoding its place if one is given
+func parseDistanceFromArg(ctx context.Context, geocoder Geocoder, df map[string]interface{}) (DistanceFrom, error) {
 	coordsSpecified := df["coordinates"] != nil
 	placeSpecified := df["place"] != nil
 	var distanceFrom DistanceFrom
-	mapstructure.Decode(args["distanceFrom"], &distanceFrom)
+	mapstructure.Decode(df, &distanceFrom)
+	distanceFrom.Min *= float32(distanceFrom.Unit.meters())
+	distanceFrom.Max *= float32(distanceFrom.Unit.meters())
+	if df["max"] == nil {
+		distanceFrom.Max = defaultFilterDistance
+	}
+	distanceFrom.Unit = UnitMeters
 	if distanceFrom.Max > maxFilterDistance {
-		return config, fmt.Errorf("<distanceFrom.Max> (%f) cannot be greater than %d", distanceFrom.Max, maxFilterDistance)
+		return distanceFrom, invalidArgumentError("distanceFrom.max", "<distanceFrom.Max> (%f meters) cannot be greater than %d meters", distanceFrom.Max, maxFilterDistance)
 	}
 
 	if placeSpecified {
-		geocoded, err := geocode(df["place"].(string))
+		geocoded, err := geocoder.Geocode(ctx, df["place"].(string))
 		if err != nil {
 			if !coordsSpecified {
-				return config, fmt.Errorf("error geocoding <place> for distanceFrom filter without fallback <coordinates> (\"%s\")", err)
+				return distanceFrom, geocodeFailedError("distanceFrom.place", "error geocoding <place> for distanceFrom filter without fallback <coordinates> (\"%s\")", err)
 			}
+		} else {
+			distanceFrom.Coordinates = geocoded
 		}
-		distanceFrom.Coordinates = geocoded
 	} else if !coordsSpecified {
-		return config, fmt.Errorf("must specify either <coordinates> or <place> on distanceFrom filter")
+		return distanceFrom, invalidArgumentError("distanceFrom", "must specify either <coordinates> or <place> on distanceFrom filter")
 	}
+	return distanceFrom, nil
+}
 
-	areaArg := args["area"]
-	var area Area
-	mapstructure.Decode(areaArg, &area)
+// parseWithinArg parses a Within filter, checking that its GeoJSON or WKT shape is a valid geometry
+func parseWithinArg(ctx context.Context, db *sqlx.DB, w map[string]interface{}) (Within, error) {
+	var within Within
+	mapstructure.Decode(w, &within)
 
-	sortArg := args["sort"]
-	var sortChoice SortChoice
-	if sortArg != nil {
-		sortChoice = SortChoice(sortArg.(int))
+	specified := 0
+	for _, shape := range []string{"bbox", "geojson", "wkt"} {
+		if w[shape] != nil {
+			specified++
+		}
+	}
+	if specified != 1 {
+		return within, invalidArgumentError("within", "must specify exactly one of <bbox>, <geojson> or <wkt> on within filter")
 	}
 
-	return rootGeographyFilterConfig{
-		distanceFrom: distanceFrom,
-		area:         optionalAreaFilter{areaArg != nil, area},
-		sortChoice:   optionalSortChoiceFilter{sortArg != nil, sortChoice},
-	}, nil
+	if w["bbox"] != nil {
+		bbox := within.BBox
+		if len(bbox) != 4 {
+			return within, invalidArgumentError("within.bbox", "<within.bbox> must be [minLon, minLat, maxLon, maxLat], got %d numbers", len(bbox))
+		}
+		if bbox[0] > bbox[2] || bbox[1] > bbox[3] {
+			return within, invalidArgumentError("within.bbox", "<within.bbox> minimum (%f, %f) cannot be greater than its maximum (%f, %f)", bbox[0], bbox[1], bbox[2], bbox[3])
+		}
+		return within, nil
+	}
+	return within, validateWithinShape(ctx, db, within)
+}
+
+// pageSort returns the sort order pages of the filtered elements are keyed on
+func (filterConfig rootGeographyFilterConfig) pageSort() string {
+	switch filterConfig.sortColumn() {
+	case "distance":
+		return pageSortDistance
+	case "area":
+		return pageSortArea
+	}
+	return pageSortID
+}
+
+// pageSortValue returns the value of a filtered element that pages are keyed on (besides its id)
+func (filterConfig rootGeographyFilterConfig) pageSortValue(distance *float64, area *float64) *float64 {
+	switch filterConfig.pageSort() {
+	case pageSortDistance:
+		return distance
+	case pageSortArea:
+		return area
+	}
+	return nil
 }
 
 type roomIntersectFilterConfig struct {
@@ -72,7 +163,7 @@ func parseRoomIntersectFilterArgs(args map[string]interface{}) (roomIntersectFil
 	if sameLevelArg != nil {
 		sameLevel = sameLevelArg.(bool)
 		if levelArg != nil {
-			return roomIntersectFilterConfig{}, fmt.Errorf("cannot filter on both <level> and <sameLevel> at the same time")
+			return roomIntersectFilterConfig{}, invalidArgumentError("sameLevel", "cannot filter on both <level> and <sameLevel> at the same time")
 		}
 	}
 
@@ -87,7 +178,7 @@ func parseRoomIntersectFilterArgs(args map[string]interface{}) (roomIntersectFil
 	if sameLevelPostfixArg != nil {
 		sameLevelPostfix = sameLevelPostfixArg.(bool)
 		if levelPostfixArg != nil {
-			return roomIntersectFilterConfig{}, fmt.Errorf("cannot filter on both <levelPostfix> and <sameLevelPostfix> at the same time")
+			return roomIntersectFilterConfig{}, invalidArgumentError("sameLevelPostfix", "cannot filter on both <levelPostfix> and <sameLevelPostfix> at the same time")
 		}
 	}
 
@@ -103,6 +194,8 @@ type buildingRoomFilterConfig struct {
 	level        optionalIntFilter
 	levelPostfix optionalStringFilter
 	name         optionalStringFilter
+	// withoutLevelPostfix only keeps rooms without a level postfix (the rooms of a level without one)
+	withoutLevelPostfix bool
 }
 
 func parseBuildingRoomFilterArgs(args map[string]interface{}) (buildingRoomFilterConfig, error) {
@@ -130,3 +223,86 @@ func parseBuildingRoomFilterArgs(args map[string]interface{}) (buildingRoomFilte
 		name:         optionalStringFilter{nameArg != nil, name},
 	}, nil
 }
+
+type locateFilterConfig struct {
+	coordinates  Coordinates
+	level        optionalIntFilter
+	levelPostfix optionalStringFilter
+	maxDistance  float64
+}
+
+func parseLocateFilterArgs(args map[string]interface{}) (locateFilterConfig, error) {
+	var coordinates Coordinates
+	mapstructure.Decode(args["coordinates"], &coordinates)
+
+	levelArg := args["level"]
+	var level int
+	if levelArg != nil {
+		level = levelArg.(int)
+	}
+
+	levelPostfixArg := args["levelPostfix"]
+	var levelPostfix string
+	if levelPostfixArg != nil {
+		levelPostfix = levelPostfixArg.(string)
+	}
+
+	maxDistance := args["maxDistance"].(float64) * parseLengthUnitArg(args["unit"]).meters()
+	if maxDistance < 0 || maxDistance > maxFilterDistance {
+		return locateFilterConfig{}, invalidArgumentError("maxDistance", "<maxDistance> (%f meters) must be between 0 and %d meters", maxDistance, maxFilterDistance)
+	}
+
+	return locateFilterConfig{
+		coordinates:  coordinates,
+		level:        optionalIntFilter{levelArg != nil, level},
+		levelPostfix: optionalStringFilter{levelPostfixArg != nil, levelPostfix},
+		maxDistance:  maxDistance,
+	}, nil
+}
+
+// parseRouteEndpointArg parses a RoomOrPoint, looking up the room if one is given
+func parseRouteEndpointArg(ctx context.Context, db *sqlx.DB, name string, arg map[string]interface{}) (routeEndpoint, error) {
+	var endpoint routeEndpoint
+
+	roomArg := arg["room"]
+	coordinatesArg := arg["coordinates"]
+	if (roomArg == nil) == (coordinatesArg == nil) {
+		return endpoint, invalidArgumentError(name, "must specify either <room> or <coordinates> (with <level>) on <%s>", name)
+	}
+
+	if roomArg != nil {
+		anchor, err := getRoomAnchor(ctx, db, roomArg.(string))
+		if err != nil {
+			return endpoint, inArgument(name, err)
+		}
+		return routeEndpoint{Coordinates{anchor.Lon, anchor.Lat}, anchor.Level, anchor.LevelPostfix, &anchor.ID}, nil
+	}
+
+	mapstructure.Decode(coordinatesArg, &endpoint.coordinates)
+	levelArg := arg["level"]
+	if levelArg == nil {
+		return endpoint, invalidArgumentError(name+".level", "must specify <level> with <coordinates> on <%s>", name)
+	}
+	endpoint.level = levelArg.(int)
+	if levelPostfixArg := arg["levelPostfix"]; levelPostfixArg != nil {
+		levelPostfix := levelPostfixArg.(string)
+		endpoint.levelPostfix = &levelPostfix
+	}
+	return endpoint, nil
+}
+
+func parseRouteArgs(ctx context.Context, db *sqlx.DB, args map[string]interface{}) (routeEndpoint, routeEndpoint, routeOptions, error) {
+	var options routeOptions
+	from, err := parseRouteEndpointArg(ctx, db, "from", args["from"].(map[string]interface{}))
+	if err != nil {
+		return from, routeEndpoint{}, options, err
+	}
+	to, err := parseRouteEndpointArg(ctx, db, "to", args["to"].(map[string]interface{}))
+	if err != nil {
+		return from, to, options, err
+	}
+	if avoidStairsArg := args["avoidStairs"]; avoidStairsArg != nil {
+		options.avoidStairs = avoidStairsArg.(bool)
+	}
+	return from, to, options, nil
+}
@@ -5,11 +5,22 @@ type optionalIntFilter struct {
 	filter int
 }
 
+type optionalFloatFilter struct {
+	use    bool
+	filter float64
+}
+
 type optionalStringFilter struct {
 	use    bool
 	filter string
 }
 
+// optionalNullableStringFilter is a string that is set (use) to a value or, if filter is nil, to null
+type optionalNullableStringFilter struct {
+	use    bool
+	filter *string
+}
+
 type optionalBoolFilter struct {
 	use    bool
 	filter bool
@@ -24,3 +35,18 @@ type optionalSortChoiceFilter struct {
 	use    bool
 	filter SortChoice
 }
+
+type optionalNearestKindFilter struct {
+	use    bool
+	filter NearestKind
+}
+
+type optionalDistanceFromFilter struct {
+	use    bool
+	filter DistanceFrom
+}
+
+type optionalWithinFilter struct {
+	use    bool
+	filter Within
+}
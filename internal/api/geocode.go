@@ -1,50 +1,290 @@
 package api
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/jmoiron/sqlx"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
-var netClient = &http.Client{
-	Timeout: time.Second * 10,
+// Geocoder resolves a free-form place query to coordinates
+type Geocoder interface {
+	Geocode(ctx context.Context, query string) (Coordinates, error)
 }
 
-func geocode(query string) (Coordinates, error) {
-	var coords Coordinates
+// noPlaceFoundError is returned when a geocoder works but doesn't know the place
+type noPlaceFoundError struct {
+	query string
+}
+
+func (err noPlaceFoundError) Error() string {
+	return fmt.Sprintf("no places found for \"%s\"", err.query)
+}
 
-	url := fmt.Sprintf("https://nominatim.openstreetmap.org/search/%s?format=json&limit=1", url.QueryEscape(query))
-	resp, err := netClient.Get(url)
+func noPlaceFound(query string) error {
+	return noPlaceFoundError{query}
+}
+
+func getJSON(ctx context.Context, client *http.Client, userAgent string, getURL string, dest interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, getURL, nil)
 	if err != nil {
-		return coords, err
+		return err
+	}
+	req = req.WithContext(ctx)
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
 	}
 
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
 	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s from %s", resp.Status, req.URL.Host)
+	}
+	return json.NewDecoder(resp.Body).Decode(dest)
+}
+
+// nominatimGeocoder geocodes using the Nominatim search api
+type nominatimGeocoder struct {
+	baseURL   string
+	userAgent string
+	client    *http.Client
+}
+
+func (g *nominatimGeocoder) Geocode(ctx context.Context, query string) (Coordinates, error) {
+	var coords Coordinates
+
+	searchURL := fmt.Sprintf("%s/search?q=%s&format=json&limit=1", strings.TrimRight(g.baseURL, "/"), url.QueryEscape(query))
+	var places []struct {
+		Lon string `json:"lon"`
+		Lat string `json:"lat"`
+	}
+	if err := getJSON(ctx, g.client, g.userAgent, searchURL, &places); err != nil {
 		return coords, err
 	}
+	if len(places) == 0 {
+		return coords, noPlaceFound(query)
+	}
 
-	var places []interface{}
-	err = json.Unmarshal(body, &places)
+	lon, err := strconv.ParseFloat(places[0].Lon, 64)
 	if err != nil {
 		return coords, err
 	}
-	if len(places) == 0 {
-		return coords, fmt.Errorf("no places found for \"%s\"", query)
+	lat, err := strconv.ParseFloat(places[0].Lat, 64)
+	if err != nil {
+		return coords, err
+	}
+	return Coordinates{lon, lat}, nil
+}
+
+// featureCollection is the subset of a GeoJSON FeatureCollection of points returned by Photon and Pelias
+type featureCollection struct {
+	Features []struct {
+		Geometry struct {
+			Coordinates []float64 `json:"coordinates"`
+		} `json:"geometry"`
+	} `json:"features"`
+}
+
+func (fc featureCollection) firstPoint(query string) (Coordinates, error) {
+	if len(fc.Features) == 0 {
+		return Coordinates{}, noPlaceFound(query)
 	}
+	point := fc.Features[0].Geometry.Coordinates
+	if len(point) < 2 {
+		return Coordinates{}, fmt.Errorf("invalid point geometry for \"%s\"", query)
+	}
+	return Coordinates{point[0], point[1]}, nil
+}
 
-	var placeInfo = places[0].(map[string]interface{})
+// photonGeocoder geocodes using the Photon api
+type photonGeocoder struct {
+	baseURL   string
+	userAgent string
+	client    *http.Client
+}
 
-	parse := func(in interface{}) (float64, error) { return strconv.ParseFloat(in.(string), 64) }
-	lon, err := parse(placeInfo["lon"])
-	lat, err := parse(placeInfo["lat"])
-	coords = Coordinates{lon, lat}
+func (g *photonGeocoder) Geocode(ctx context.Context, query string) (Coordinates, error) {
+	searchURL := fmt.Sprintf("%s/api?q=%s&limit=1", strings.TrimRight(g.baseURL, "/"), url.QueryEscape(query))
+	var fc featureCollection
+	if err := getJSON(ctx, g.client, g.userAgent, searchURL, &fc); err != nil {
+		return Coordinates{}, err
+	}
+	return fc.firstPoint(query)
+}
 
+// peliasGeocoder geocodes using the Pelias search api
+type peliasGeocoder struct {
+	baseURL   string
+	apiKey    string
+	userAgent string
+	client    *http.Client
+}
+
+func (g *peliasGeocoder) Geocode(ctx context.Context, query string) (Coordinates, error) {
+	searchURL := fmt.Sprintf("%s/v1/search?text=%s&size=1", strings.TrimRight(g.baseURL, "/"), url.QueryEscape(query))
+	if g.apiKey != "" {
+		searchURL += "&api_key=" + url.QueryEscape(g.apiKey)
+	}
+	var fc featureCollection
+	if err := getJSON(ctx, g.client, g.userAgent, searchURL, &fc); err != nil {
+		return Coordinates{}, err
+	}
+	return fc.firstPoint(query)
+}
+
+// gazetteerGeocoder geocodes offline using our own building names and addresses
+type gazetteerGeocoder struct {
+	db *sqlx.DB
+}
+
+func (g *gazetteerGeocoder) Geocode(ctx context.Context, query string) (Coordinates, error) {
+	coords, err := getGazetteerCoordinates(ctx, g.db, query)
+	if err == sql.ErrNoRows {
+		return coords, noPlaceFound(query)
+	}
 	return coords, err
 }
+
+// chainGeocoder tries each geocoder in order and returns the first result.
+// If none of them fail but none know the place either, it returns a noPlaceFoundError.
+type chainGeocoder []Geocoder
+
+func (chain chainGeocoder) Geocode(ctx context.Context, query string) (Coordinates, error) {
+	var errs []string
+	for _, geocoder := range chain {
+		coords, err := geocoder.Geocode(ctx, query)
+		if err == nil {
+			return coords, nil
+		}
+		if _, notFound := err.(noPlaceFoundError); !notFound {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) == 0 {
+		return Coordinates{}, noPlaceFound(query)
+	}
+	return Coordinates{}, errors.New(strings.Join(errs, "; "))
+}
+
+var errCircuitOpen = errors.New("geocoder temporarily disabled after repeated failures")
+
+// circuitBreaker stops calling a failing geocoder for a cooldown period after maxFailures
+// consecutive failures. Finding no place or the caller's context ending is not a failure.
+type circuitBreaker struct {
+	name        string
+	geocoder    Geocoder
+	maxFailures int
+	cooldown    time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+func (cb *circuitBreaker) Geocode(ctx context.Context, query string) (Coordinates, error) {
+	cb.mu.Lock()
+	if time.Now().Before(cb.openUntil) {
+		cb.mu.Unlock()
+		geocodeCalls.WithLabelValues(cb.name, "circuit_open").Inc()
+		return Coordinates{}, fmt.Errorf("%s: %v", cb.name, errCircuitOpen)
+	}
+	cb.mu.Unlock()
+
+	start := time.Now()
+	coords, err := cb.geocoder.Geocode(ctx, query)
+	geocodeDuration.WithLabelValues(cb.name).Observe(time.Since(start).Seconds())
+	if err == nil {
+		geocodeCalls.WithLabelValues(cb.name, "found").Inc()
+		cb.mu.Lock()
+		cb.failures = 0
+		cb.mu.Unlock()
+		return coords, nil
+	}
+	if _, notFound := err.(noPlaceFoundError); notFound {
+		geocodeCalls.WithLabelValues(cb.name, "not_found").Inc()
+		return coords, err
+	}
+	if ctx.Err() != nil {
+		// The caller gave up (or ran out of time) while the request was running, that says nothing about the geocoder
+		geocodeCalls.WithLabelValues(cb.name, "canceled").Inc()
+		return coords, fmt.Errorf("%s: %v", cb.name, err)
+	}
+	geocodeCalls.WithLabelValues(cb.name, "failed").Inc()
+
+	cb.mu.Lock()
+	cb.failures++
+	if cb.failures >= cb.maxFailures {
+		cb.openUntil = time.Now().Add(cb.cooldown)
+		// A single failure after the cooldown (half-open) opens the circuit again
+		cb.failures = cb.maxFailures - 1
+	}
+	cb.mu.Unlock()
+	return coords, fmt.Errorf("%s: %v", cb.name, err)
+}
+
+// newGeocoder builds the chain of geocoders listed in config.Providers behind a cache.
+// Online geocoders are rate limited in front of their circuit breaker, so time spent waiting for the
+// rate limit is neither an upstream failure nor part of the upstream duration.
+func newGeocoder(ctx context.Context, config GeocoderConfig, db *sqlx.DB) (Geocoder, error) {
+	client := &http.Client{
+		Timeout:   config.Timeout,
+		Transport: otelhttp.NewTransport(http.DefaultTransport),
+	}
+
+	var chain chainGeocoder
+	for _, provider := range config.Providers {
+		var geocoder Geocoder
+		switch provider {
+		case "nominatim":
+			geocoder = &nominatimGeocoder{config.NominatimURL, config.UserAgent, client}
+		case "photon":
+			geocoder = &photonGeocoder{config.PhotonURL, config.UserAgent, client}
+		case "pelias":
+			geocoder = &peliasGeocoder{config.PeliasURL, config.PeliasAPIKey, config.UserAgent, client}
+		case "gazetteer":
+			geocoder = &gazetteerGeocoder{db}
+		default:
+			return nil, fmt.Errorf("unknown geocoder provider \"%s\"", provider)
+		}
+		geocoder = &circuitBreaker{
+			name:        provider,
+			geocoder:    geocoder,
+			maxFailures: config.BreakerFailures,
+			cooldown:    config.BreakerCooldown,
+		}
+		if provider != "gazetteer" {
+			geocoder = &rateLimitedGeocoder{geocoder, newTokenBucket(config.RateLimit, config.RateBurst)}
+		}
+		chain = append(chain, geocoder)
+	}
+
+	cache := &cachingGeocoder{
+		upstream:    chain,
+		memory:      newLRUCache(config.CacheSize),
+		ttl:         config.CacheTTL,
+		negativeTTL: config.NegativeTTL,
+		timeout:     config.Timeout * time.Duration(len(chain)), // every provider may take up to the timeout
+		inflight:    map[string]*geocodeCall{},
+	}
+	if config.PersistentCache {
+		if err := ensureGeocodeCacheTable(ctx, db); err != nil {
+			return nil, fmt.Errorf("error creating persistent geocode cache: %v", err)
+		}
+		cache.db = db
+	}
+	return cache, nil
+}
@@ -0,0 +1,269 @@
+package api
+
+import (
+	"container/list"
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// normalizeGeocodeQuery makes equivalent place queries share a cache key
+func normalizeGeocodeQuery(query string) string {
+	return strings.Join(strings.Fields(strings.ToLower(query)), " ")
+}
+
+// geocodeCacheEntry is a cached geocoding result, found is false for negative entries
+type geocodeCacheEntry struct {
+	Query     string
+	Lon       float64
+	Lat       float64
+	Found     bool
+	ExpiresAt time.Time `db:"expires_at"`
+}
+
+func (entry geocodeCacheEntry) result() (Coordinates, error) {
+	if !entry.Found {
+		return Coordinates{}, noPlaceFound(entry.Query)
+	}
+	return Coordinates{entry.Lon, entry.Lat}, nil
+}
+
+// lruCache is an in-memory, fixed size geocode cache that evicts the least recently used entry
+type lruCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{
+		size:    size,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+func (c *lruCache) get(key string) (geocodeCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, exists := c.entries[key]
+	if !exists {
+		return geocodeCacheEntry{}, false
+	}
+	entry := element.Value.(geocodeCacheEntry)
+	if time.Now().After(entry.ExpiresAt) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return geocodeCacheEntry{}, false
+	}
+	c.order.MoveToFront(element)
+	return entry, true
+}
+
+func (c *lruCache) put(entry geocodeCacheEntry) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, exists := c.entries[entry.Query]; exists {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[entry.Query] = c.order.PushFront(entry)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(geocodeCacheEntry).Query)
+	}
+}
+
+// geocodeCall is an upstream geocoding request that concurrent callers for the same query wait on
+type geocodeCall struct {
+	done   chan struct{}
+	coords Coordinates
+	err    error
+}
+
+// detachedContext keeps the values (e.g. the trace) of its parent but not its cancellation or deadline
+type detachedContext struct {
+	parent context.Context
+}
+
+func (ctx detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (ctx detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (ctx detachedContext) Err() error {
+	return nil
+}
+
+func (ctx detachedContext) Value(key interface{}) interface{} {
+	return ctx.parent.Value(key)
+}
+
+// cachingGeocoder caches the results of its upstream geocoder in memory and optionally in postgres,
+// and coalesces concurrent requests for the same query into a single upstream request. That request
+// doesn't depend on any caller staying connected and is limited by timeout instead.
+type cachingGeocoder struct {
+	upstream    Geocoder
+	memory      *lruCache
+	db          *sqlx.DB
+	ttl         time.Duration
+	negativeTTL time.Duration
+	timeout     time.Duration
+
+	mu       sync.Mutex
+	inflight map[string]*geocodeCall
+}
+
+func (g *cachingGeocoder) Geocode(ctx context.Context, query string) (Coordinates, error) {
+	key := normalizeGeocodeQuery(query)
+
+	if entry, hit := g.memory.get(key); hit {
+		geocodeCacheLookups.WithLabelValues("memory", "hit").Inc()
+		return entry.result()
+	}
+	geocodeCacheLookups.WithLabelValues("memory", "miss").Inc()
+
+	g.mu.Lock()
+	call, exists := g.inflight[key]
+	if !exists {
+		call = &geocodeCall{done: make(chan struct{})}
+		g.inflight[key] = call
+		go g.resolve(detachedContext{ctx}, key, call)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.coords, call.err
+	case <-ctx.Done():
+		return Coordinates{}, ctx.Err()
+	}
+}
+
+// resolve looks up key for everyone waiting on call
+func (g *cachingGeocoder) resolve(ctx context.Context, key string, call *geocodeCall) {
+	if g.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.timeout)
+		defer cancel()
+	}
+	call.coords, call.err = g.lookup(ctx, key)
+
+	g.mu.Lock()
+	delete(g.inflight, key)
+	g.mu.Unlock()
+	close(call.done)
+}
+
+// lookup tries the persistent cache and then the upstream geocoder, caching what it finds
+func (g *cachingGeocoder) lookup(ctx context.Context, key string) (Coordinates, error) {
+	if g.db != nil {
+		entry, err := getGeocodeCacheEntry(ctx, g.db, key)
+		if err == nil {
+			geocodeCacheLookups.WithLabelValues("postgres", "hit").Inc()
+			g.memory.put(entry)
+			return entry.result()
+		}
+		geocodeCacheLookups.WithLabelValues("postgres", "miss").Inc()
+		if err != errNoGeocodeCacheEntry {
+			log.Printf("error reading persistent geocode cache: %v", err)
+		}
+	}
+
+	coords, err := g.upstream.Geocode(ctx, key)
+	// A provider that timed out might have known the place
+	if ctx.Err() != nil {
+		return coords, err
+	}
+	entry := geocodeCacheEntry{Query: key, Lon: coords.Lon, Lat: coords.Lat, Found: true}
+	if err != nil {
+		if _, notFound := err.(noPlaceFoundError); !notFound {
+			return coords, err
+		}
+		entry.Found = false
+		entry.ExpiresAt = time.Now().Add(g.negativeTTL)
+	} else {
+		entry.ExpiresAt = time.Now().Add(g.ttl)
+	}
+
+	g.memory.put(entry)
+	if g.db != nil {
+		if err := putGeocodeCacheEntry(ctx, g.db, entry); err != nil {
+			log.Printf("error writing persistent geocode cache: %v", err)
+		}
+	}
+	return entry.result()
+}
+
+// tokenBucket allows rate requests per second on average with bursts of up to burst requests
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until a token is available or ctx is done
+func (b *tokenBucket) wait(ctx context.Context) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens--
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		b.tokens++
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// rateLimitedGeocoder waits for its token bucket before every upstream request
+type rateLimitedGeocoder struct {
+	geocoder Geocoder
+	bucket   *tokenBucket
+}
+
+func (g *rateLimitedGeocoder) Geocode(ctx context.Context, query string) (Coordinates, error) {
+	if err := g.bucket.wait(ctx); err != nil {
+		return Coordinates{}, err
+	}
+	return g.geocoder.Geocode(ctx, query)
+}
@@ -0,0 +1,263 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/graphql-go/graphql"
+	"github.com/jmoiron/sqlx"
+)
+
+// GeometryFormat defines how a geometry is encoded in a response
+type GeometryFormat int
+
+// GeometryFormat enum
+const (
+	GeometryWKT      GeometryFormat = 0
+	GeometryGeoJSON  GeometryFormat = 1
+	GeometryEWKB     GeometryFormat = 2
+	GeometryPolyline GeometryFormat = 3
+)
+
+const maxGeometryPrecision = 15
+
+// geometryEncoding is a requested format with optional simplification and precision reduction
+type geometryEncoding struct {
+	format    GeometryFormat
+	simplify  optionalFloatFilter
+	precision optionalIntFilter
+}
+
+func parseGeometryEncodingArgs(args map[string]interface{}) (geometryEncoding, error) {
+	var encoding geometryEncoding
+	if formatArg := args["format"]; formatArg != nil {
+		encoding.format = GeometryFormat(formatArg.(int))
+	}
+
+	if simplifyArg := args["simplify"]; simplifyArg != nil {
+		simplify := simplifyArg.(float64)
+		if simplify < 0 {
+			return encoding, invalidArgumentError("simplify", "<simplify> (%f) cannot be negative", simplify)
+		}
+		encoding.simplify = optionalFloatFilter{true, simplify}
+	}
+
+	if precisionArg := args["precision"]; precisionArg != nil {
+		precision := precisionArg.(int)
+		if precision < 0 || precision > maxGeometryPrecision {
+			return encoding, invalidArgumentError("precision", "<precision> (%d) must be between 0 and %d", precision, maxGeometryPrecision)
+		}
+		encoding.precision = optionalIntFilter{true, precision}
+	}
+
+	return encoding, nil
+}
+
+// isStored reports whether the encoding is the plain WKT that is already selected with every element
+func (encoding geometryEncoding) isStored() bool {
+	return encoding.format == GeometryWKT && !encoding.simplify.use && !encoding.precision.use
+}
+
+// columnExpr returns the sql expression that encodes the geometry column.
+// Only numbers are interpolated so the expression is safe to embed in a query.
+func (encoding geometryEncoding) columnExpr() string {
+	geom := "geometry"
+	if encoding.simplify.use {
+		geom = fmt.Sprintf("ST_SimplifyPreserveTopology(%s, %s)", geom, strconv.FormatFloat(encoding.simplify.filter, 'g', -1, 64))
+	}
+	if encoding.precision.use {
+		gridSize := math.Pow(10, -float64(encoding.precision.filter))
+		geom = fmt.Sprintf("ST_SnapToGrid(%s, %s)", geom, strconv.FormatFloat(gridSize, 'g', -1, 64))
+	}
+
+	switch encoding.format {
+	case GeometryGeoJSON:
+		if encoding.precision.use {
+			return fmt.Sprintf("ST_AsGeoJSON(%s, %d)", geom, encoding.precision.filter)
+		}
+		return fmt.Sprintf("ST_AsGeoJSON(%s)", geom)
+	case GeometryEWKB:
+		return fmt.Sprintf("ST_AsHexEWKB(%s)", geom)
+	case GeometryPolyline:
+		// Outline of the (first) polygon
+		polyline := fmt.Sprintf("ST_ExteriorRing(ST_GeometryN(ST_Multi(%s), 1))", geom)
+		if encoding.precision.use {
+			return fmt.Sprintf("ST_AsEncodedPolyline(%s, %d)", polyline, encoding.precision.filter)
+		}
+		return fmt.Sprintf("ST_AsEncodedPolyline(%s)", polyline)
+	default:
+		return fmt.Sprintf("ST_AsText(%s)", geom)
+	}
+}
+
+// tableConfig returns a config that selects only the id and the encoded geometry of the table's elements
+func (encoding geometryEncoding) tableConfig(tableConfig TableConfig) TableConfig {
+	return TableConfig{
+		TableName:         tableConfig.TableName,
+		ElementName:       tableConfig.ElementName,
+		ElementNamePlural: tableConfig.ElementNamePlural,
+		Columns:           fmt.Sprintf("id, %s AS geometry", encoding.columnExpr()),
+	}
+}
+
+// encodedGeometry is an element's geometry in a requested encoding
+type encodedGeometry struct {
+	ID       int
+	Geometry *string
+}
+
+func (encoding geometryEncoding) output(encoded string) interface{} {
+	if encoding.format == GeometryGeoJSON {
+		return json.RawMessage(encoded)
+	}
+	return encoded
+}
+
+// measuredGeometry is a length or area computed from an element's geometry
+type measuredGeometry struct {
+	ID    int
+	Value float64
+}
+
+// geometryPoint is a point computed from an element's geometry
+type geometryPoint struct {
+	ID int
+	Coordinates
+}
+
+// geometryBoundingBox is the bounding box of an element's geometry
+type geometryBoundingBox struct {
+	ID int
+	BoundingBox
+}
+
+// gqlSpatialField is a field computed from the geometry of an element of tableConfig. columns selects the
+// value (besides the id) into element and value returns the field's value from it. Like encoded geometries
+// the value is only queried when it is selected, for all elements of a request at once.
+func gqlSpatialField(db *sqlx.DB, tableConfig TableConfig, fieldType graphql.Output, description string, columns string,
+	element interface{}, id func(source interface{}) int, value func(element interface{}) interface{}) *graphql.Field {
+	propertyConfig := TableConfig{
+		TableName:         tableConfig.TableName,
+		ElementName:       tableConfig.ElementName,
+		ElementNamePlural: tableConfig.ElementNamePlural,
+		Columns:           "id, " + columns,
+	}
+	return &graphql.Field{
+		Type:        fieldType,
+		Description: description,
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			thunk := loadByID(params.Context, db, propertyConfig, id(params.Source), element)
+			return func() (interface{}, error) {
+				element, err := thunk()
+				if err != nil {
+					return nil, err
+				}
+				return value(element), nil
+			}, nil
+		},
+	}
+}
+
+// gqlSpatialFields returns the area, perimeter, centroid, labelPoint and boundingBox fields of elements of tableConfig
+func gqlSpatialFields(db *sqlx.DB, tableConfig TableConfig, lonLatType *graphql.Object, boundingBoxType *graphql.Object, id func(source interface{}) int) graphql.Fields {
+	measure := func(element interface{}) interface{} {
+		return element.(measuredGeometry).Value
+	}
+	point := func(element interface{}) interface{} {
+		return element.(geometryPoint).Coordinates
+	}
+	return graphql.Fields{
+		"area": gqlInUnit(true, gqlSpatialField(db, tableConfig, graphql.Float, "Area in square units",
+			"ST_Area(geometry::geography) AS value", measuredGeometry{}, id, measure)),
+		"perimeter": gqlInUnit(false, gqlSpatialField(db, tableConfig, graphql.Float, "Perimeter in units",
+			"ST_Perimeter(geometry::geography) AS value", measuredGeometry{}, id, measure)),
+		"centroid": gqlSpatialField(db, tableConfig, lonLatType, "Geometric center, which can lie outside the geometry",
+			"ST_X(ST_Centroid(geometry)) AS lon, ST_Y(ST_Centroid(geometry)) AS lat", geometryPoint{}, id, point),
+		"labelPoint": gqlSpatialField(db, tableConfig, lonLatType, "A point inside the geometry to put a label on",
+			"ST_X(ST_PointOnSurface(geometry)) AS lon, ST_Y(ST_PointOnSurface(geometry)) AS lat", geometryPoint{}, id, point),
+		"boundingBox": gqlSpatialField(db, tableConfig, boundingBoxType, "",
+			"ST_XMin(geometry) AS min_lon, ST_YMin(geometry) AS min_lat, ST_XMax(geometry) AS max_lon, ST_YMax(geometry) AS max_lat",
+			geometryBoundingBox{}, id, func(element interface{}) interface{} {
+				return element.(geometryBoundingBox).BoundingBox
+			}),
+	}
+}
+
+// geometryEncodingArgs are the arguments of geometry fields, parsed by parseGeometryEncodingArgs
+var geometryEncodingArgs = graphql.FieldConfigArgument{
+	"format": &graphql.ArgumentConfig{
+		Type:         geometryFormatEnum,
+		DefaultValue: int(GeometryWKT),
+	},
+	"simplify": &graphql.ArgumentConfig{
+		Type:        graphql.Float,
+		Description: "Simplification tolerance, in units of the geometry",
+	},
+	"precision": &graphql.ArgumentConfig{
+		Type:        graphql.Int,
+		Description: "Number of decimal digits to keep in coordinates",
+	},
+}
+
+// geometryScalar is a WKT, EWKB or polyline string or a GeoJSON object depending on the requested format
+var geometryScalar = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "Geometry",
+	Description: "A geometry encoded as a WKT, hex EWKB or encoded polyline string, or as a GeoJSON object",
+	Serialize: func(value interface{}) interface{} {
+		return value
+	},
+})
+
+var geometryFormatEnum = graphql.NewEnum(graphql.EnumConfig{
+	Name: "GeometryFormat",
+	Values: graphql.EnumValueConfigMap{
+		"WKT": &graphql.EnumValueConfig{
+			Value: int(GeometryWKT),
+		},
+		"GEOJSON": &graphql.EnumValueConfig{
+			Value: int(GeometryGeoJSON),
+		},
+		"EWKB": &graphql.EnumValueConfig{
+			Value:       int(GeometryEWKB),
+			Description: "Hex encoded extended well-known binary",
+		},
+		"POLYLINE": &graphql.EnumValueConfig{
+			Value:       int(GeometryPolyline),
+			Description: "Encoded polyline of the outline",
+		},
+	},
+})
+
+// gqlGeometryField is a geometry field on an element of tableConfig. idAndWKT returns the
+// element's id and its stored WKT geometry.
+func gqlGeometryField(db *sqlx.DB, tableConfig TableConfig, idAndWKT func(source interface{}) (int, string)) *graphql.Field {
+	return &graphql.Field{
+		Type: geometryScalar,
+		Args: geometryEncodingArgs,
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			encoding, err := parseGeometryEncodingArgs(params.Args)
+			if err != nil {
+				return nil, err
+			}
+			id, wkt := idAndWKT(params.Source)
+			if encoding.isStored() {
+				return wkt, nil
+			}
+
+			thunk := loadByID(params.Context, db, encoding.tableConfig(tableConfig), id, encodedGeometry{})
+			return func() (interface{}, error) {
+				element, err := thunk()
+				if err != nil {
+					return nil, err
+				}
+				encoded := element.(encodedGeometry).Geometry
+				if encoded == nil {
+					return nil, nil
+				}
+				return encoding.output(*encoded), nil
+			}, nil
+		},
+	}
+}
@@ -13,6 +13,22 @@ func gqlSF(scalar *graphql.Scalar) *graphql.Field {
 	}
 }
 
+// gqlAuthorized wraps field's resolver so it fails for callers without role
+func gqlAuthorized(role string, field *graphql.Field) *graphql.Field {
+	resolve := field.Resolve
+	if resolve == nil {
+		resolve = graphql.DefaultResolveFn
+	}
+	authorized := *field
+	authorized.Resolve = func(params graphql.ResolveParams) (interface{}, error) {
+		if err := requireRole(params.Context, role); err != nil {
+			return nil, err
+		}
+		return resolve(params)
+	}
+	return &authorized
+}
+
 func gqlRootGeographyFilteredObject(name string, fieldName string, wrappedType *graphql.Object) *graphql.Object {
 	return graphql.NewObject(graphql.ObjectConfig{
 		Name: name,
@@ -20,32 +36,106 @@ func gqlRootGeographyFilteredObject(name string, fieldName string, wrappedType *
 			fieldName: &graphql.Field{
 				Type: wrappedType,
 			},
-			"distance": gqlSF(graphql.Float),
-			"area":     gqlSF(graphql.Float),
+			"distance": gqlInUnit(false, gqlSF(graphql.Float)),
+			"area":     gqlInUnit(true, gqlSF(graphql.Float)),
+		},
+	})
+}
+
+func gqlConnectionObject(name string, nodeType graphql.Output, pageInfoType *graphql.Object) *graphql.Object {
+	edgeType := graphql.NewObject(graphql.ObjectConfig{
+		Name: name + "Edge",
+		Fields: graphql.Fields{
+			"node": &graphql.Field{
+				Type: nodeType,
+			},
+			"cursor": gqlSF(graphql.String),
+		},
+	})
+
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: name + "Connection",
+		Fields: graphql.Fields{
+			"edges": &graphql.Field{
+				Type: &graphql.List{
+					OfType: edgeType,
+				},
+			},
+			"pageInfo": &graphql.Field{
+				Type: pageInfoType,
+			},
+			"totalCount": &graphql.Field{
+				Type: graphql.Int,
+				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+					return params.Source.(Connection).count()
+				},
+			},
 		},
 	})
 }
 
+// gqlConnectionArgs returns args with the relay pagination arguments added
+func gqlConnectionArgs(args graphql.FieldConfigArgument) graphql.FieldConfigArgument {
+	connectionArgs := graphql.FieldConfigArgument{
+		"first": &graphql.ArgumentConfig{
+			Type: graphql.Int,
+		},
+		"after": &graphql.ArgumentConfig{
+			Type: graphql.String,
+		},
+		"last": &graphql.ArgumentConfig{
+			Type: graphql.Int,
+		},
+		"before": &graphql.ArgumentConfig{
+			Type: graphql.String,
+		},
+	}
+	for name, arg := range args {
+		connectionArgs[name] = arg
+	}
+	return connectionArgs
+}
+
+// gqlMergeFields returns fields with the extra fields added
+func gqlMergeFields(fields graphql.Fields, extra graphql.Fields) graphql.Fields {
+	for name, field := range extra {
+		fields[name] = field
+	}
+	return fields
+}
+
 const maxFilterDistance = 2000
+const defaultFilterDistance = 500
 
-func generateSchema(db *sqlx.DB) graphql.Schema {
+func generateSchema(db *sqlx.DB, geocoder Geocoder, router Router) graphql.Schema {
 	/*
 		> Types
 		Mirrors the sql datastructure but with extra fields for indirect relations.
 	*/
+	var pageInfoType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "PageInfo",
+		Fields: graphql.Fields{
+			"hasNextPage":     gqlSF(graphql.Boolean),
+			"hasPreviousPage": gqlSF(graphql.Boolean),
+			"startCursor":     gqlSF(graphql.String),
+			"endCursor":       gqlSF(graphql.String),
+		},
+	})
+
 	var surveyType graphql.Object
 	var osmElementType graphql.Object
 	var importType graphql.Object
 	var dataSourceType graphql.Object
 	var addressType graphql.Object
 	var buildingType graphql.Object
+	var levelType graphql.Object
 	var roomType graphql.Object
 
 	surveyType = *graphql.NewObject(graphql.ObjectConfig{
 		Name: "Survey",
 		Fields: graphql.Fields{
 			"uid":      gqlSF(graphql.String),
-			"surveyor": gqlSF(graphql.String),
+			"surveyor": gqlAuthorized(roleSurveyor, gqlSF(graphql.String)),
 			"external": gqlSF(graphql.Boolean),
 		},
 	})
@@ -85,9 +175,7 @@ func generateSchema(db *sqlx.DB) graphql.Schema {
 					if id == nil {
 						return nil, nil
 					}
-					var osmElement OsmElement
-					err := getByID(db, osmElementConfig, *id, &osmElement)
-					return osmElement, err
+					return loadByID(params.Context, db, osmElementConfig, *id, OsmElement{}), nil
 				},
 			},
 			"survey": &graphql.Field{
@@ -97,18 +185,17 @@ func generateSchema(db *sqlx.DB) graphql.Schema {
 					if id == nil {
 						return nil, nil
 					}
-					var survey Survey
-					err := getByID(db, surveyConfig, *id, &survey)
-					return survey, err
+					return loadByID(params.Context, db, surveyConfig, *id, Survey{}), nil
 				},
 			},
 			"import": &graphql.Field{
 				Type: &importType,
 				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
 					id := params.Source.(DataSource).Import
-					var simport Simport
-					err := getByID(db, simportConfig, id, &simport)
-					return simport, err
+					if id == nil {
+						return nil, nil
+					}
+					return loadByID(params.Context, db, simportConfig, *id, Simport{}), nil
 				},
 			},
 		},
@@ -125,64 +212,199 @@ func generateSchema(db *sqlx.DB) graphql.Schema {
 		},
 	})
 
+	buildingRoomFilterArgs := graphql.FieldConfigArgument{
+		"level": &graphql.ArgumentConfig{
+			Type: graphql.Int,
+		},
+		"levelPostfix": &graphql.ArgumentConfig{
+			Type: graphql.String,
+		},
+		"name": &graphql.ArgumentConfig{
+			Type: graphql.String,
+		},
+	}
+
+	// The rooms of a level take the building's room filters, except the level the level itself fixes
+	levelRoomFilterArgs := graphql.FieldConfigArgument{}
+	for name, arg := range buildingRoomFilterArgs {
+		if name != "level" && name != "levelPostfix" {
+			levelRoomFilterArgs[name] = arg
+		}
+	}
+
+	roomConnectionType := gqlConnectionObject("Room", &roomType, pageInfoType)
+
+	var lonLatType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "LonLat",
+		Fields: graphql.Fields{
+			"lon": gqlSF(graphql.Float),
+			"lat": gqlSF(graphql.Float),
+		},
+	})
+
+	var boundingBoxType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "BoundingBox",
+		Fields: graphql.Fields{
+			"minLon": gqlSF(graphql.Float),
+			"minLat": gqlSF(graphql.Float),
+			"maxLon": gqlSF(graphql.Float),
+			"maxLat": gqlSF(graphql.Float),
+		},
+	})
+
+	levelType = *graphql.NewObject(graphql.ObjectConfig{
+		Name: "Level",
+		Fields: graphql.Fields{
+			"level":        gqlSF(graphql.Int),
+			"levelPostfix": gqlSF(graphql.String),
+			"name": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Display name, e.g. \"Ground floor\", \"-1\" or \"0M\"",
+				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+					return params.Source.(Level).name(), nil
+				},
+			},
+			"roomCount": gqlSF(graphql.Int),
+			"outline": &graphql.Field{
+				Type:        geometryScalar,
+				Description: "Union of the level's rooms",
+				Args:        geometryEncodingArgs,
+				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+					encoding, err := parseGeometryEncodingArgs(params.Args)
+					if err != nil {
+						return nil, err
+					}
+					outline, err := getLevelOutline(params.Context, db, params.Source.(Level), encoding)
+					if err != nil || outline == nil {
+						return nil, err
+					}
+					return encoding.output(*outline), nil
+				},
+			},
+			"boundingBox": &graphql.Field{
+				Type: boundingBoxType,
+				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+					return params.Source.(Level).BoundingBox, nil
+				},
+			},
+			"isEntrance": &graphql.Field{
+				Type: graphql.Boolean,
+				Description: "Whether this is the level the building is probably entered on. There is no entrance data, " +
+					"so it is guessed as the lowest level >= 0, or the highest level if all are below 0",
+			},
+			"rooms": &graphql.Field{
+				Type: &graphql.List{
+					OfType: &roomType,
+				},
+				Args: levelRoomFilterArgs,
+				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+					filterConfig, err := parseBuildingRoomFilterArgs(params.Args)
+					if err != nil {
+						return nil, err
+					}
+					level := params.Source.(Level)
+					filterConfig.level = optionalIntFilter{true, level.Level}
+					if level.LevelPostfix != nil {
+						filterConfig.levelPostfix = optionalStringFilter{true, *level.LevelPostfix}
+					} else {
+						filterConfig.withoutLevelPostfix = true
+					}
+					return getFilteredRoomsByBuildingID(params.Context, db, filterConfig, level.Building)
+				},
+			},
+		},
+	})
+
 	buildingType = *graphql.NewObject(graphql.ObjectConfig{
 		Name: "Building",
-		Fields: graphql.Fields{
-			"uid":      gqlSF(graphql.String),
-			"name":     gqlSF(graphql.String),
-			"geometry": gqlSF(graphql.String),
+		Fields: gqlMergeFields(graphql.Fields{
+			"uid":     gqlSF(graphql.String),
+			"name":    gqlSF(graphql.String),
+			"version": gqlSF(graphql.Int),
+			"geometry": gqlGeometryField(db, buildingConfig, func(source interface{}) (int, string) {
+				building := source.(Building)
+				return building.ID, building.Geometry
+			}),
 			"address": &graphql.Field{
 				Type: &addressType,
 				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
 					id := params.Source.(Building).Address
-					var address Address
-					err := getByID(db, addressConfig, id, &address)
-					return address, err
+					return loadByID(params.Context, db, addressConfig, id, Address{}), nil
 				},
 			},
 			"dataSource": &graphql.Field{
 				Type: &dataSourceType,
 				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
 					id := params.Source.(Building).DataSource
-					var dataSource DataSource
-					err := getByID(db, dataSourceConfig, id, &dataSource)
-					return dataSource, err
+					return loadByID(params.Context, db, dataSourceConfig, id, DataSource{}), nil
 				},
 			},
 			"rooms": &graphql.Field{
 				Type: &graphql.List{
 					OfType: &roomType,
 				},
-				Args: graphql.FieldConfigArgument{
-					"level": &graphql.ArgumentConfig{
-						Type: graphql.Int,
-					},
-					"levelPostfix": &graphql.ArgumentConfig{
-						Type: graphql.String,
-					},
-					"name": &graphql.ArgumentConfig{
-						Type: graphql.String,
-					},
-				},
+				Args:              buildingRoomFilterArgs,
+				DeprecationReason: "Returns at most 500 rooms, use roomsConnection",
 				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
 					filterConfig, err := parseBuildingRoomFilterArgs(params.Args)
 					if err != nil {
 						return nil, err
 					}
 					id := params.Source.(Building).ID
-					rooms, err := getFilteredRoomsByBuildingID(db, filterConfig, id)
+					rooms, err := getFilteredRoomsByBuildingID(params.Context, db, filterConfig, id)
 					return rooms, err
 				},
 			},
-		},
+			"levels": &graphql.Field{
+				Type: &graphql.List{
+					OfType: &levelType,
+				},
+				Description: "Levels of the building's rooms from the bottom up",
+				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+					return getBuildingLevels(params.Context, db, params.Source.(Building).ID)
+				},
+			},
+			"roomsConnection": &graphql.Field{
+				Type: roomConnectionType,
+				Args: gqlConnectionArgs(buildingRoomFilterArgs),
+				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+					filterConfig, err := parseBuildingRoomFilterArgs(params.Args)
+					if err != nil {
+						return nil, err
+					}
+					page, err := parsePageArgs(params.Args, pageSortID)
+					if err != nil {
+						return nil, err
+					}
+					id := params.Source.(Building).ID
+					rooms, err := getFilteredRoomsByBuildingIDPage(params.Context, db, filterConfig, id, page)
+					if err != nil {
+						return nil, err
+					}
+					keyOf := func(element interface{}) (*float64, int) {
+						return nil, element.(Room).ID
+					}
+					count := func() (int, error) {
+						return countFilteredRoomsByBuildingID(params.Context, db, filterConfig, id)
+					}
+					return page.connection(rooms, keyOf, count), nil
+				},
+			},
+		}, gqlSpatialFields(db, buildingConfig, lonLatType, boundingBoxType, func(source interface{}) int {
+			return source.(Building).ID
+		})),
 	})
 
 	roomType = *graphql.NewObject(graphql.ObjectConfig{
 		Name: "Room",
-		Fields: graphql.Fields{
-			"uid":          gqlSF(graphql.String),
-			"name":         gqlSF(graphql.String),
-			"geometry":     gqlSF(graphql.String),
+		Fields: gqlMergeFields(graphql.Fields{
+			"uid":     gqlSF(graphql.String),
+			"name":    gqlSF(graphql.String),
+			"version": gqlSF(graphql.Int),
+			"geometry": gqlGeometryField(db, roomConfig, func(source interface{}) (int, string) {
+				room := source.(Room)
+				return room.ID, room.Geometry
+			}),
 			"level":        gqlSF(graphql.Int),
 			"levelPostfix": gqlSF(graphql.String),
 			"ref":          gqlSF(graphql.String),
@@ -190,18 +412,14 @@ func generateSchema(db *sqlx.DB) graphql.Schema {
 				Type: &buildingType,
 				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
 					id := params.Source.(Room).Building
-					var building Building
-					err := getByID(db, buildingConfig, id, &building)
-					return building, err
+					return loadByID(params.Context, db, buildingConfig, id, Building{}), nil
 				},
 			},
 			"dataSource": &graphql.Field{
 				Type: &dataSourceType,
 				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
 					id := params.Source.(Room).DataSource
-					var dataSource DataSource
-					err := getByID(db, dataSourceConfig, id, &dataSource)
-					return dataSource, err
+					return loadByID(params.Context, db, dataSourceConfig, id, DataSource{}), nil
 				},
 			},
 			"intersecting": &graphql.Field{
@@ -228,15 +446,19 @@ func generateSchema(db *sqlx.DB) graphql.Schema {
 						return nil, err
 					}
 					id := params.Source.(Room).ID
-					rooms, err := getIntersectingRooms(db, filterConfig, id)
+					rooms, err := getIntersectingRooms(params.Context, db, filterConfig, id)
 					return rooms, err
 				},
 			},
-		},
+		}, gqlSpatialFields(db, roomConfig, lonLatType, boundingBoxType, func(source interface{}) int {
+			return source.(Room).ID
+		})),
 	})
 
 	var filteredRoomType = *gqlRootGeographyFilteredObject("FilteredRoom", "room", &roomType)
 	var filteredBuildingType = *gqlRootGeographyFilteredObject("FilteredBuilding", "building", &buildingType)
+	var filteredRoomConnectionType = gqlConnectionObject("FilteredRoom", &filteredRoomType, pageInfoType)
+	var filteredBuildingConnectionType = gqlConnectionObject("FilteredBuilding", &filteredBuildingType, pageInfoType)
 
 	var coordinatesType = graphql.NewInputObject(
 		graphql.InputObjectConfig{
@@ -263,12 +485,55 @@ func generateSchema(db *sqlx.DB) graphql.Schema {
 					Type: graphql.String,
 				},
 				"min": &graphql.InputObjectFieldConfig{
-					Type:         graphql.Int,
-					DefaultValue: 0,
+					Type:         graphql.Float,
+					DefaultValue: 0.0,
 				},
 				"max": &graphql.InputObjectFieldConfig{
-					Type:         graphql.Int,
-					DefaultValue: 500,
+					Type:        graphql.Float,
+					Description: "Defaults to 500 meters",
+				},
+				"unit": &graphql.InputObjectFieldConfig{
+					Type:         lengthUnitEnum,
+					DefaultValue: int(UnitMeters),
+					Description:  "Unit of min and max",
+				},
+			},
+		},
+	)
+
+	var spatialRelationEnum = graphql.NewEnum(graphql.EnumConfig{
+		Name: "SpatialRelation",
+		Values: graphql.EnumValueConfigMap{
+			"INTERSECTS": &graphql.EnumValueConfig{
+				Value:       int(RelationIntersects),
+				Description: "Elements that overlap or touch the shape",
+			},
+			"CONTAINS": &graphql.EnumValueConfig{
+				Value:       int(RelationContains),
+				Description: "Elements entirely inside the shape",
+			},
+		},
+	})
+
+	var withinType = graphql.NewInputObject(
+		graphql.InputObjectConfig{
+			Name:        "Within",
+			Description: "Exactly one of a bounding box, GeoJSON or WKT geometry (in lon/lat)",
+			Fields: graphql.InputObjectConfigFieldMap{
+				"bbox": &graphql.InputObjectFieldConfig{
+					Type:        graphql.NewList(graphql.NewNonNull(graphql.Float)),
+					Description: "[minLon, minLat, maxLon, maxLat]",
+				},
+				"geojson": &graphql.InputObjectFieldConfig{
+					Type:        graphql.String,
+					Description: "GeoJSON geometry object",
+				},
+				"wkt": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"relation": &graphql.InputObjectFieldConfig{
+					Type:         spatialRelationEnum,
+					DefaultValue: int(RelationIntersects),
 				},
 			},
 		},
@@ -279,12 +544,17 @@ func generateSchema(db *sqlx.DB) graphql.Schema {
 			Name: "Area",
 			Fields: graphql.InputObjectConfigFieldMap{
 				"min": &graphql.InputObjectFieldConfig{
-					Type:         graphql.Int,
-					DefaultValue: 0,
+					Type:         graphql.Float,
+					DefaultValue: 0.0,
 				},
 				"max": &graphql.InputObjectFieldConfig{
-					Type:         graphql.Int,
-					DefaultValue: 500,
+					Type:        graphql.Float,
+					Description: "Unbounded if omitted",
+				},
+				"unit": &graphql.InputObjectFieldConfig{
+					Type:         lengthUnitEnum,
+					DefaultValue: int(UnitMeters),
+					Description:  "Unit of min and max, squared",
 				},
 			},
 		},
@@ -304,7 +574,10 @@ func generateSchema(db *sqlx.DB) graphql.Schema {
 
 	rootGeographyFilterArgs := graphql.FieldConfigArgument{
 		"distanceFrom": &graphql.ArgumentConfig{
-			Type: graphql.NewNonNull(distanceFromType),
+			Type: distanceFromType,
+		},
+		"within": &graphql.ArgumentConfig{
+			Type: withinType,
 		},
 		"area": &graphql.ArgumentConfig{
 			Type: areaType,
@@ -314,6 +587,165 @@ func generateSchema(db *sqlx.DB) graphql.Schema {
 		},
 	}
 
+	var locatedRoomType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "LocatedRoom",
+		Fields: graphql.Fields{
+			"room": &graphql.Field{
+				Type: &roomType,
+			},
+			"contains": gqlSF(graphql.Boolean),
+			"distance": gqlInUnit(false, gqlSF(graphql.Float)),
+		},
+	})
+
+	var locationType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Location",
+		Fields: graphql.Fields{
+			"building": &graphql.Field{
+				Type: &buildingType,
+				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+					building := params.Source.(Location).Building
+					if building == nil {
+						return nil, nil
+					}
+					return *building, nil
+				},
+			},
+			"rooms": &graphql.Field{
+				Type: &graphql.List{
+					OfType: locatedRoomType,
+				},
+			},
+		},
+	})
+
+	var searchItemType = graphql.NewUnion(graphql.UnionConfig{
+		Name:  "SearchItem",
+		Types: []*graphql.Object{&roomType, &buildingType},
+		ResolveType: func(params graphql.ResolveTypeParams) *graphql.Object {
+			switch params.Value.(type) {
+			case Room:
+				return &roomType
+			case Building:
+				return &buildingType
+			}
+			return nil
+		},
+	})
+
+	var searchSpanType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "SearchSpan",
+		Fields: graphql.Fields{
+			"start": gqlSF(graphql.Int),
+			"end":   gqlSF(graphql.Int),
+		},
+	})
+
+	var searchMatchType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "SearchMatch",
+		Fields: graphql.Fields{
+			"field": gqlSF(graphql.String),
+			"text":  gqlSF(graphql.String),
+			"highlights": &graphql.Field{
+				Type: &graphql.List{
+					OfType: searchSpanType,
+				},
+			},
+		},
+	})
+
+	var searchResultType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "SearchResult",
+		Fields: graphql.Fields{
+			"item": &graphql.Field{
+				Type: searchItemType,
+			},
+			"score": gqlSF(graphql.Float),
+			"matches": &graphql.Field{
+				Type: &graphql.List{
+					OfType: searchMatchType,
+				},
+			},
+		},
+	})
+
+	var nearestKindEnum = graphql.NewEnum(graphql.EnumConfig{
+		Name: "NearestKind",
+		Values: graphql.EnumValueConfigMap{
+			"ROOM": &graphql.EnumValueConfig{
+				Value: int(NearestRoom),
+			},
+			"BUILDING": &graphql.EnumValueConfig{
+				Value: int(NearestBuilding),
+			},
+		},
+	})
+
+	var nearestResultType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "NearestResult",
+		Fields: graphql.Fields{
+			"item": &graphql.Field{
+				Type: searchItemType,
+			},
+			"distance": gqlInUnit(false, gqlSF(graphql.Float)),
+		},
+	})
+
+	var roomOrPointType = graphql.NewInputObject(
+		graphql.InputObjectConfig{
+			Name:        "RoomOrPoint",
+			Description: "Either a room (uid) or coordinates on a level",
+			Fields: graphql.InputObjectConfigFieldMap{
+				"room": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"coordinates": &graphql.InputObjectFieldConfig{
+					Type: coordinatesType,
+				},
+				"level": &graphql.InputObjectFieldConfig{
+					Type: graphql.Int,
+				},
+				"levelPostfix": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+			},
+		},
+	)
+
+	var levelChangeType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "LevelChange",
+		Fields: graphql.Fields{
+			"via":            gqlSF(graphql.String),
+			"toLevel":        gqlSF(graphql.Int),
+			"toLevelPostfix": gqlSF(graphql.String),
+		},
+	})
+
+	var routeLegType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "RouteLeg",
+		Fields: graphql.Fields{
+			"level":        gqlSF(graphql.Int),
+			"levelPostfix": gqlSF(graphql.String),
+			"geometry":     gqlSF(graphql.String),
+			"length":       gqlInUnit(false, gqlSF(graphql.Float)),
+			"levelChange": &graphql.Field{
+				Type: levelChangeType,
+			},
+		},
+	})
+
+	var routeType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Route",
+		Fields: graphql.Fields{
+			"length": gqlInUnit(false, gqlSF(graphql.Float)),
+			"legs": &graphql.Field{
+				Type: &graphql.List{
+					OfType: routeLegType,
+				},
+			},
+		},
+	})
+
 	uidArgs := graphql.FieldConfigArgument{
 		"uid": &graphql.ArgumentConfig{
 			Type: graphql.NewNonNull(graphql.String),
@@ -332,7 +764,7 @@ func generateSchema(db *sqlx.DB) graphql.Schema {
 			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
 				uid := params.Args["uid"].(string)
 				var building Building
-				err := getByUID(db, buildingConfig, uid, &building)
+				err := getByUID(params.Context, db, buildingConfig, "uid", uid, &building)
 				return building, err
 			},
 		},
@@ -342,7 +774,7 @@ func generateSchema(db *sqlx.DB) graphql.Schema {
 			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
 				uid := params.Args["uid"].(string)
 				var room Room
-				err := getByUID(db, roomConfig, uid, &room)
+				err := getByUID(params.Context, db, roomConfig, "uid", uid, &room)
 				return room, err
 			},
 		},
@@ -350,14 +782,15 @@ func generateSchema(db *sqlx.DB) graphql.Schema {
 			Type: &graphql.List{
 				OfType: &filteredRoomType,
 			},
-			Args: rootGeographyFilterArgs,
+			Args:              rootGeographyFilterArgs,
+			DeprecationReason: "Returns at most 500 rooms, use roomsConnection",
 			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-				filterConfig, err := parseRootGeographyFilterArgs(params.Args)
+				filterConfig, err := parseRootGeographyFilterArgs(params.Context, db, geocoder, params.Args)
 				if err != nil {
 					return nil, err
 				}
 				var rooms []FilteredRoom
-				err = getFiltered(db, roomConfig, filterConfig, &rooms)
+				err = getFiltered(params.Context, db, roomConfig, filterConfig, &rooms)
 				return rooms, err
 			},
 		},
@@ -365,34 +798,220 @@ func generateSchema(db *sqlx.DB) graphql.Schema {
 			Type: &graphql.List{
 				OfType: &filteredBuildingType,
 			},
-			Args: rootGeographyFilterArgs,
+			Args:              rootGeographyFilterArgs,
+			DeprecationReason: "Returns at most 500 buildings, use buildingsConnection",
 			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-				filterConfig, err := parseRootGeographyFilterArgs(params.Args)
+				filterConfig, err := parseRootGeographyFilterArgs(params.Context, db, geocoder, params.Args)
 				if err != nil {
 					return nil, err
 				}
 				var buildings []FilteredBuilding
-				err = getFiltered(db, buildingConfig, filterConfig, &buildings)
+				err = getFiltered(params.Context, db, buildingConfig, filterConfig, &buildings)
 				return buildings, err
 			},
 		},
-		"import": &graphql.Field{
-			Type: &importType,
-			Args: uidArgs,
+		"roomsConnection": &graphql.Field{
+			Type: filteredRoomConnectionType,
+			Args: gqlConnectionArgs(rootGeographyFilterArgs),
 			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-				uid := params.Args["uid"].(string)
-				var simport Simport
-				err := getByUID(db, simportConfig, uid, &simport)
-				return simport, err
+				filterConfig, err := parseRootGeographyFilterArgs(params.Context, db, geocoder, params.Args)
+				if err != nil {
+					return nil, err
+				}
+				page, err := parsePageArgs(params.Args, filterConfig.pageSort())
+				if err != nil {
+					return nil, err
+				}
+				var rooms []FilteredRoom
+				err = getFilteredPage(params.Context, db, roomConfig, filterConfig, page, &rooms)
+				if err != nil {
+					return nil, err
+				}
+				keyOf := func(element interface{}) (*float64, int) {
+					room := element.(FilteredRoom)
+					return filterConfig.pageSortValue(room.Distance, room.Area), room.ID
+				}
+				count := func() (int, error) {
+					return countFiltered(params.Context, db, roomConfig, filterConfig)
+				}
+				return page.connection(rooms, keyOf, count), nil
 			},
 		},
-		"osmElement": &graphql.Field{
+		"buildingsConnection": &graphql.Field{
+			Type: filteredBuildingConnectionType,
+			Args: gqlConnectionArgs(rootGeographyFilterArgs),
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				filterConfig, err := parseRootGeographyFilterArgs(params.Context, db, geocoder, params.Args)
+				if err != nil {
+					return nil, err
+				}
+				page, err := parsePageArgs(params.Args, filterConfig.pageSort())
+				if err != nil {
+					return nil, err
+				}
+				var buildings []FilteredBuilding
+				err = getFilteredPage(params.Context, db, buildingConfig, filterConfig, page, &buildings)
+				if err != nil {
+					return nil, err
+				}
+				keyOf := func(element interface{}) (*float64, int) {
+					building := element.(FilteredBuilding)
+					return filterConfig.pageSortValue(building.Distance, building.Area), building.ID
+				}
+				count := func() (int, error) {
+					return countFiltered(params.Context, db, buildingConfig, filterConfig)
+				}
+				return page.connection(buildings, keyOf, count), nil
+			},
+		},
+		"search": &graphql.Field{
+			Type: &graphql.List{
+				OfType: searchResultType,
+			},
+			Args: graphql.FieldConfigArgument{
+				"query": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"near": &graphql.ArgumentConfig{
+					Type: coordinatesType,
+				},
+				"limit": &graphql.ArgumentConfig{
+					Type: graphql.Int,
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				filterConfig, err := parseSearchFilterArgs(params.Args)
+				if err != nil {
+					return nil, err
+				}
+				rooms, err := searchRooms(params.Context, db, filterConfig)
+				if err != nil {
+					return nil, err
+				}
+				buildings, err := searchBuildings(params.Context, db, filterConfig)
+				if err != nil {
+					return nil, err
+				}
+				return mergeSearchResults(filterConfig, rooms, buildings), nil
+			},
+		},
+		"nearest": &graphql.Field{
+			Type: &graphql.List{
+				OfType: nearestResultType,
+			},
+			Args: graphql.FieldConfigArgument{
+				"coordinates": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(coordinatesType),
+				},
+				"k": &graphql.ArgumentConfig{
+					Type:         graphql.Int,
+					DefaultValue: defaultNearestK,
+				},
+				"kind": &graphql.ArgumentConfig{
+					Type:        nearestKindEnum,
+					Description: "Kind of elements to return, both rooms and buildings if omitted",
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				filterConfig, err := parseNearestFilterArgs(params.Args)
+				if err != nil {
+					return nil, err
+				}
+				var rooms []FilteredRoom
+				if filterConfig.includes(NearestRoom) {
+					err = getNearest(params.Context, db, roomConfig, filterConfig.coordinates, filterConfig.k, &rooms)
+					if err != nil {
+						return nil, err
+					}
+				}
+				var buildings []FilteredBuilding
+				if filterConfig.includes(NearestBuilding) {
+					err = getNearest(params.Context, db, buildingConfig, filterConfig.coordinates, filterConfig.k, &buildings)
+					if err != nil {
+						return nil, err
+					}
+				}
+				return mergeNearestResults(filterConfig, rooms, buildings), nil
+			},
+		},
+		"route": &graphql.Field{
+			Type: routeType,
+			Args: graphql.FieldConfigArgument{
+				"from": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(roomOrPointType),
+				},
+				"to": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(roomOrPointType),
+				},
+				"avoidStairs": &graphql.ArgumentConfig{
+					Type:         graphql.Boolean,
+					DefaultValue: false,
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				from, to, options, err := parseRouteArgs(params.Context, db, params.Args)
+				if err != nil {
+					return nil, err
+				}
+				return router.Route(params.Context, from, to, options)
+			},
+		},
+		"locate": &graphql.Field{
+			Type: locationType,
+			Args: graphql.FieldConfigArgument{
+				"coordinates": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(coordinatesType),
+				},
+				"level": &graphql.ArgumentConfig{
+					Type: graphql.Int,
+				},
+				"levelPostfix": &graphql.ArgumentConfig{
+					Type: graphql.String,
+				},
+				"maxDistance": &graphql.ArgumentConfig{
+					Type:         graphql.Float,
+					DefaultValue: 20.0,
+				},
+				"unit": &graphql.ArgumentConfig{
+					Type:         lengthUnitEnum,
+					DefaultValue: int(UnitMeters),
+					Description:  "Unit of maxDistance",
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				filterConfig, err := parseLocateFilterArgs(params.Args)
+				if err != nil {
+					return nil, err
+				}
+				building, err := getContainingBuilding(params.Context, db, filterConfig.coordinates)
+				if err != nil {
+					return nil, err
+				}
+				var buildingID *int
+				if building != nil {
+					buildingID = &building.ID
+				}
+				rooms, err := getLocatedRooms(params.Context, db, filterConfig, buildingID)
+				return Location{building, rooms}, err
+			},
+		},
+		"import": &graphql.Field{
+			Type: &importType,
+			Args: uidArgs,
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				uid := params.Args["uid"].(string)
+				var simport Simport
+				err := getByUID(params.Context, db, simportConfig, "uid", uid, &simport)
+				return simport, err
+			},
+		},
+		"osmElement": &graphql.Field{
 			Type: &osmElementType,
 			Args: uidArgs,
 			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
 				uid := params.Args["uid"].(string)
 				var osmElement OsmElement
-				err := getByUID(db, osmElementConfig, uid, &osmElement)
+				err := getByUID(params.Context, db, osmElementConfig, "uid", uid, &osmElement)
 				return osmElement, err
 			},
 		},
@@ -402,12 +1021,428 @@ func generateSchema(db *sqlx.DB) graphql.Schema {
 			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
 				uid := params.Args["uid"].(string)
 				var survey Survey
-				err := getByUID(db, surveyConfig, uid, &survey)
+				err := getByUID(params.Context, db, surveyConfig, "uid", uid, &survey)
 				return survey, err
 			},
 		},
 	}
 
+	for name, field := range rootFields {
+		rootFields[name] = gqlAuthorized(roleReader, field)
+	}
+
+	/*
+		> Mutations
+		Survey-driven edits, each recorded as a new data source of the survey.
+		Updates and deletes must pass the version they are based on.
+	*/
+	var addressInputType = graphql.NewInputObject(
+		graphql.InputObjectConfig{
+			Name: "AddressInput",
+			Fields: graphql.InputObjectConfigFieldMap{
+				"free": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"locality": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"region": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"postcode": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"country": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+			},
+		},
+	)
+
+	var clearableRoomFieldEnum = graphql.NewEnum(graphql.EnumConfig{
+		Name: "ClearableRoomField",
+		Values: graphql.EnumValueConfigMap{
+			"NAME": &graphql.EnumValueConfig{
+				Value: "name",
+			},
+			"REF": &graphql.EnumValueConfig{
+				Value: "ref",
+			},
+			"LEVEL_POSTFIX": &graphql.EnumValueConfig{
+				Value: "levelPostfix",
+			},
+		},
+	})
+
+	var clearableBuildingFieldEnum = graphql.NewEnum(graphql.EnumConfig{
+		Name: "ClearableBuildingField",
+		Values: graphql.EnumValueConfigMap{
+			"NAME": &graphql.EnumValueConfig{
+				Value: "name",
+			},
+		},
+	})
+
+	var createRoomInputType = graphql.NewInputObject(
+		graphql.InputObjectConfig{
+			Name: "CreateRoomInput",
+			Fields: graphql.InputObjectConfigFieldMap{
+				"survey": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"building": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"geometry": &graphql.InputObjectFieldConfig{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "WKT",
+				},
+				"level": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"levelPostfix": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"name": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"ref": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+			},
+		},
+	)
+
+	var updateRoomInputType = graphql.NewInputObject(
+		graphql.InputObjectConfig{
+			Name: "UpdateRoomInput",
+			Fields: graphql.InputObjectConfigFieldMap{
+				"uid": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"version": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"survey": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"building": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"geometry": &graphql.InputObjectFieldConfig{
+					Type:        graphql.String,
+					Description: "WKT",
+				},
+				"level": &graphql.InputObjectFieldConfig{
+					Type: graphql.Int,
+				},
+				"levelPostfix": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"name": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"ref": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"clear": &graphql.InputObjectFieldConfig{
+					Type:        graphql.NewList(graphql.NewNonNull(clearableRoomFieldEnum)),
+					Description: "Fields to set to null",
+				},
+			},
+		},
+	)
+
+	var createBuildingInputType = graphql.NewInputObject(
+		graphql.InputObjectConfig{
+			Name: "CreateBuildingInput",
+			Fields: graphql.InputObjectConfigFieldMap{
+				"survey": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"geometry": &graphql.InputObjectFieldConfig{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "WKT",
+				},
+				"address": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(addressInputType),
+				},
+				"name": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+			},
+		},
+	)
+
+	var updateBuildingInputType = graphql.NewInputObject(
+		graphql.InputObjectConfig{
+			Name: "UpdateBuildingInput",
+			Fields: graphql.InputObjectConfigFieldMap{
+				"uid": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"version": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"survey": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"geometry": &graphql.InputObjectFieldConfig{
+					Type:        graphql.String,
+					Description: "WKT",
+				},
+				"address": &graphql.InputObjectFieldConfig{
+					Type: addressInputType,
+				},
+				"name": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"clear": &graphql.InputObjectFieldConfig{
+					Type:        graphql.NewList(graphql.NewNonNull(clearableBuildingFieldEnum)),
+					Description: "Fields to set to null",
+				},
+			},
+		},
+	)
+
+	mutationFields := graphql.Fields{
+		"createSurvey": gqlAuthorized(roleSurveyor, &graphql.Field{
+			Type: &surveyType,
+			Args: graphql.FieldConfigArgument{
+				"surveyor": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"external": &graphql.ArgumentConfig{
+					Type:         graphql.Boolean,
+					DefaultValue: false,
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				survey, err := parseCreateSurveyArgs(params.Args)
+				if err != nil {
+					return nil, err
+				}
+				return createSurvey(params.Context, db, survey)
+			},
+		}),
+		"createRoom": gqlAuthorized(roleSurveyor, &graphql.Field{
+			Type: &roomType,
+			Args: graphql.FieldConfigArgument{
+				"input": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(createRoomInputType),
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				input := params.Args["input"].(map[string]interface{})
+				survey, err := parseSurveyArg(input)
+				if err != nil {
+					return nil, inArgument("input", err)
+				}
+				edit, err := parseRoomEditArgs(input)
+				if err != nil {
+					return nil, inArgument("input", err)
+				}
+				element, err := createRoom(params.Context, db, survey, edit)
+				return element, inArgument("input", err)
+			},
+		}),
+		"updateRoom": gqlAuthorized(roleSurveyor, &graphql.Field{
+			Type: &roomType,
+			Args: graphql.FieldConfigArgument{
+				"input": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(updateRoomInputType),
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				input := params.Args["input"].(map[string]interface{})
+				survey, err := parseSurveyArg(input)
+				if err != nil {
+					return nil, inArgument("input", err)
+				}
+				edit, err := parseRoomEditArgs(input)
+				if err != nil {
+					return nil, inArgument("input", err)
+				}
+				element, err := updateRoom(params.Context, db, survey, parseElementVersionArgs(input), edit)
+				return element, inArgument("input", err)
+			},
+		}),
+		"deleteRoom": gqlAuthorized(roleAdmin, &graphql.Field{
+			Type: &roomType,
+			Args: graphql.FieldConfigArgument{
+				"uid": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"version": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"survey": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				survey, err := parseSurveyArg(params.Args)
+				if err != nil {
+					return nil, err
+				}
+				return deleteRoom(params.Context, db, survey, parseElementVersionArgs(params.Args))
+			},
+		}),
+		"createBuilding": gqlAuthorized(roleSurveyor, &graphql.Field{
+			Type: &buildingType,
+			Args: graphql.FieldConfigArgument{
+				"input": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(createBuildingInputType),
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				input := params.Args["input"].(map[string]interface{})
+				survey, err := parseSurveyArg(input)
+				if err != nil {
+					return nil, inArgument("input", err)
+				}
+				edit, err := parseBuildingEditArgs(input)
+				if err != nil {
+					return nil, inArgument("input", err)
+				}
+				element, err := createBuilding(params.Context, db, survey, edit)
+				return element, inArgument("input", err)
+			},
+		}),
+		"updateBuilding": gqlAuthorized(roleSurveyor, &graphql.Field{
+			Type: &buildingType,
+			Args: graphql.FieldConfigArgument{
+				"input": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(updateBuildingInputType),
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				input := params.Args["input"].(map[string]interface{})
+				survey, err := parseSurveyArg(input)
+				if err != nil {
+					return nil, inArgument("input", err)
+				}
+				edit, err := parseBuildingEditArgs(input)
+				if err != nil {
+					return nil, inArgument("input", err)
+				}
+				element, err := updateBuilding(params.Context, db, survey, parseElementVersionArgs(input), edit)
+				return element, inArgument("input", err)
+			},
+		}),
+	}
+
+	/*
+		> Subscriptions
+		Executed once for every change event, see subscriptionEvent.
+	*/
+	var changeKindEnum = graphql.NewEnum(graphql.EnumConfig{
+		Name: "ChangeKind",
+		Values: graphql.EnumValueConfigMap{
+			changeCreated: &graphql.EnumValueConfig{
+				Value: changeCreated,
+			},
+			changeUpdated: &graphql.EnumValueConfig{
+				Value: changeUpdated,
+			},
+			changeDeleted: &graphql.EnumValueConfig{
+				Value: changeDeleted,
+			},
+		},
+	})
+
+	var roomChangeType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "RoomChange",
+		Fields: graphql.Fields{
+			"kind": &graphql.Field{
+				Type: changeKindEnum,
+			},
+			"uid": gqlSF(graphql.String),
+			"room": &graphql.Field{
+				Type:        &roomType,
+				Description: "The room after the change, null if it was deleted or moved out of the building",
+				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+					event := params.Source.(*ChangeEvent)
+					if event.Kind == changeDeleted {
+						return nil, nil
+					}
+					return loadByID(params.Context, db, roomConfig, event.ID, Room{}), nil
+				},
+			},
+		},
+	})
+
+	var buildingChangeType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "BuildingChange",
+		Fields: graphql.Fields{
+			"kind": &graphql.Field{
+				Type: changeKindEnum,
+			},
+			"uid": gqlSF(graphql.String),
+			"building": &graphql.Field{
+				Type:        &buildingType,
+				Description: "The building after the change, null if it was deleted",
+				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+					event := params.Source.(*ChangeEvent)
+					if event.Kind == changeDeleted {
+						return nil, nil
+					}
+					return loadByID(params.Context, db, buildingConfig, event.ID, Building{}), nil
+				},
+			},
+		},
+	})
+
+	subscriptionFields := graphql.Fields{
+		"roomChanged": &graphql.Field{
+			Type: roomChangeType,
+			Args: graphql.FieldConfigArgument{
+				"buildingUid": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				buildingUID := params.Args["buildingUid"].(string)
+				event := subscriptionEvent(params, roomChangedChannel, func(event ChangeEvent) bool {
+					return event.Building != nil && *event.Building == buildingUID
+				})
+				if event == nil {
+					return nil, nil
+				}
+				return event, nil
+			},
+		},
+		"buildingChanged": &graphql.Field{
+			Type: buildingChangeType,
+			Args: uidArgs,
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				uid := params.Args["uid"].(string)
+				event := subscriptionEvent(params, buildingChangedChannel, func(event ChangeEvent) bool {
+					return event.UID == uid
+				})
+				if event == nil {
+					return nil, nil
+				}
+				return event, nil
+			},
+		},
+		"importCompleted": &graphql.Field{
+			Type: &importType,
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				event := subscriptionEvent(params, importCompletedChannel, func(event ChangeEvent) bool {
+					return true
+				})
+				if event == nil {
+					return nil, nil
+				}
+				return loadByID(params.Context, db, simportConfig, event.ID, Simport{}), nil
+			},
+		},
+	}
+	for name, field := range subscriptionFields {
+		subscriptionFields[name] = gqlAuthorized(roleReader, field)
+	}
+
 	/*
 		> Schema
 	*/
@@ -417,7 +1452,19 @@ func generateSchema(db *sqlx.DB) graphql.Schema {
 			Fields: rootFields,
 		},
 	)
-	schemaConfig := graphql.SchemaConfig{Query: rootQuery}
+	rootMutation := graphql.NewObject(
+		graphql.ObjectConfig{
+			Name:   "RootMutation",
+			Fields: mutationFields,
+		},
+	)
+	rootSubscription := graphql.NewObject(
+		graphql.ObjectConfig{
+			Name:   "RootSubscription",
+			Fields: subscriptionFields,
+		},
+	)
+	schemaConfig := graphql.SchemaConfig{Query: rootQuery, Mutation: rootMutation, Subscription: rootSubscription}
 	schema, err := graphql.NewSchema(schemaConfig)
 	if err != nil {
 		log.Fatalf("failed to create new schema, error: %v", err)
@@ -0,0 +1,318 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/jmoiron/sqlx"
+)
+
+// graphql-ws (subscriptions-transport-ws) message types
+const (
+	gqlwsConnectionInit      = "connection_init"
+	gqlwsConnectionAck       = "connection_ack"
+	gqlwsConnectionError     = "connection_error"
+	gqlwsKeepAlive           = "ka"
+	gqlwsConnectionTerminate = "connection_terminate"
+	gqlwsStart               = "start"
+	gqlwsData                = "data"
+	gqlwsError               = "error"
+	gqlwsComplete            = "complete"
+	gqlwsStop                = "stop"
+)
+
+const gqlwsKeepAliveInterval = time.Second * 30
+const gqlwsReadLimit = 1 << 20
+
+type gqlwsMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type gqlwsStartPayload struct {
+	Query         string                   `json:"query"`
+	Variables     map[string]interface{}   `json:"variables"`
+	OperationName string                   `json:"operationName"`
+	Extensions    persistedQueryExtensions `json:"extensions"`
+}
+
+// subscriptionHandler serves graphql operations, subscriptions in particular, over websockets with the graphql-ws protocol
+type subscriptionHandler struct {
+	schema         *graphql.Schema
+	db             *sqlx.DB
+	hub            *changeHub
+	authenticators []Authenticator
+	limits         costLimits
+	persisted      *persistedQueries
+	upgrader       websocket.Upgrader
+
+	mu    sync.Mutex
+	conns map[*websocket.Conn]struct{}
+}
+
+func newSubscriptionHandler(schema *graphql.Schema, db *sqlx.DB, hub *changeHub, authenticators []Authenticator, limits costLimits, persisted *persistedQueries) *subscriptionHandler {
+	return &subscriptionHandler{
+		schema:         schema,
+		db:             db,
+		hub:            hub,
+		authenticators: authenticators,
+		limits:         limits,
+		persisted:      persisted,
+		upgrader: websocket.Upgrader{
+			Subprotocols: []string{"graphql-ws"},
+			// Callers authenticate with tokens, not cookies, so any origin is fine
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+		conns: map[*websocket.Conn]struct{}{},
+	}
+}
+
+// handler serves websocket upgrades and passes all other requests to next
+func (h *subscriptionHandler) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !websocket.IsWebSocketUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		conn, err := h.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		h.mu.Lock()
+		h.conns[conn] = struct{}{}
+		h.mu.Unlock()
+
+		c := &gqlwsConnection{
+			handler:    h,
+			conn:       conn,
+			identity:   identityFromContext(r.Context()),
+			operations: map[string]func(){},
+			done:       make(chan struct{}),
+		}
+		c.serve()
+
+		h.mu.Lock()
+		delete(h.conns, conn)
+		h.mu.Unlock()
+	})
+}
+
+// closeAll closes all websocket connections, the http server doesn't track them itself
+func (h *subscriptionHandler) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for conn := range h.conns {
+		conn.Close()
+	}
+}
+
+// gqlwsConnection is a single graphql-ws client connection
+type gqlwsConnection struct {
+	handler  *subscriptionHandler
+	conn     *websocket.Conn
+	identity *Identity
+	writeMu  sync.Mutex
+	done     chan struct{}
+
+	mu         sync.Mutex
+	operations map[string]func()
+}
+
+func (c *gqlwsConnection) send(id string, messageType string, payload interface{}) {
+	message := gqlwsMessage{ID: id, Type: messageType}
+	if payload != nil {
+		message.Payload, _ = json.Marshal(payload)
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.WriteJSON(message)
+}
+
+func (c *gqlwsConnection) sendError(id string, messageType string, err error) {
+	c.send(id, messageType, map[string]interface{}{"message": err.Error()})
+}
+
+func (c *gqlwsConnection) serve() {
+	defer func() {
+		close(c.done)
+		c.mu.Lock()
+		for _, stop := range c.operations {
+			stop()
+		}
+		c.mu.Unlock()
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(gqlwsReadLimit)
+	for {
+		var message gqlwsMessage
+		if err := c.conn.ReadJSON(&message); err != nil {
+			return
+		}
+		switch message.Type {
+		case gqlwsConnectionInit:
+			if err := c.authenticate(message.Payload); err != nil {
+				c.sendError("", gqlwsConnectionError, err)
+				return
+			}
+			c.send("", gqlwsConnectionAck, nil)
+			go c.keepAlive()
+		case gqlwsStart:
+			c.start(message.ID, message.Payload)
+		case gqlwsStop:
+			c.stop(message.ID)
+		case gqlwsConnectionTerminate:
+			return
+		default:
+			c.sendError(message.ID, gqlwsError, fmt.Errorf("unknown message type \"%s\"", message.Type))
+		}
+	}
+}
+
+// authenticate replaces the connection's identity with the caller of the credentials in the connection_init
+// payload, if there are any. Browsers can't set headers on websocket requests.
+func (c *gqlwsConnection) authenticate(payload json.RawMessage) error {
+	var params map[string]interface{}
+	json.Unmarshal(payload, &params)
+	header := http.Header{}
+	for key, value := range params {
+		if s, ok := value.(string); ok && (strings.EqualFold(key, "Authorization") || strings.EqualFold(key, "X-API-Key")) {
+			header.Set(key, s)
+		}
+	}
+	if len(header) == 0 {
+		return nil
+	}
+
+	r := &http.Request{Header: header}
+	for _, authenticator := range c.handler.authenticators {
+		identity, err := authenticator.Authenticate(r)
+		if err != nil {
+			return err
+		}
+		if identity != nil {
+			c.identity = identity
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported credentials")
+}
+
+func (c *gqlwsConnection) keepAlive() {
+	ticker := time.NewTicker(gqlwsKeepAliveInterval)
+	defer ticker.Stop()
+	c.send("", gqlwsKeepAlive, nil)
+	for {
+		select {
+		case <-ticker.C:
+			c.send("", gqlwsKeepAlive, nil)
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// operationType returns the type (query, mutation or subscription) of the operation that would be executed
+func operationType(query string, operationName string) string {
+	document, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		return ""
+	}
+	for _, definition := range document.Definitions {
+		operation, ok := definition.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if operationName == "" || (operation.Name != nil && operation.Name.Value == operationName) {
+			return operation.Operation
+		}
+	}
+	return ""
+}
+
+func (c *gqlwsConnection) execute(payload gqlwsStartPayload, cost *QueryCost, execution *subscriptionExecution) *graphql.Result {
+	return formatResultErrors(graphql.Do(graphql.Params{
+		Schema:         *c.handler.schema,
+		RequestString:  payload.Query,
+		VariableValues: payload.Variables,
+		OperationName:  payload.OperationName,
+		RootObject:     subscriptionRoot(execution),
+		Context:        withLoaders(withQueryCost(withIdentity(context.Background(), c.identity), cost), c.handler.db),
+	}))
+}
+
+// start runs a query or mutation once, or sends the result of a subscription for every matching event until stopped
+func (c *gqlwsConnection) start(id string, rawPayload json.RawMessage) {
+	var payload gqlwsStartPayload
+	if err := json.Unmarshal(rawPayload, &payload); err != nil {
+		c.sendError(id, gqlwsError, fmt.Errorf("invalid start payload"))
+		return
+	}
+	query, err := c.handler.persisted.resolve(context.Background(), payload.Query, payload.Extensions.PersistedQuery)
+	if err != nil {
+		c.send(id, gqlwsError, []gqlerrors.FormattedError{formatError(err)})
+		return
+	}
+	payload.Query = query
+
+	cost := c.handler.limits.analyze(c.handler.schema, payload.Query, payload.OperationName, payload.Variables)
+	if err := c.handler.limits.check(cost); err != nil {
+		c.send(id, gqlwsError, []gqlerrors.FormattedError{formatError(err)})
+		return
+	}
+
+	if operationType(payload.Query, payload.OperationName) != ast.OperationTypeSubscription {
+		c.send(id, gqlwsData, c.execute(payload, cost, &subscriptionExecution{}))
+		c.send(id, gqlwsComplete, nil)
+		return
+	}
+
+	// Executing without an event checks the operation (and the caller's permissions) up front
+	if result := c.execute(payload, cost, &subscriptionExecution{}); result.HasErrors() {
+		c.send(id, gqlwsError, result.Errors)
+		return
+	}
+
+	c.mu.Lock()
+	if _, exists := c.operations[id]; exists {
+		c.mu.Unlock()
+		c.sendError(id, gqlwsError, fmt.Errorf("an operation with id %s is already running", id))
+		return
+	}
+	events, unsubscribe := c.handler.hub.subscribe()
+	c.operations[id] = unsubscribe
+	c.mu.Unlock()
+
+	go func() {
+		for event := range events {
+			event := event
+			execution := &subscriptionExecution{event: &event}
+			result := c.execute(payload, cost, execution)
+			if execution.matched {
+				c.send(id, gqlwsData, result)
+			}
+		}
+	}()
+}
+
+func (c *gqlwsConnection) stop(id string) {
+	c.mu.Lock()
+	unsubscribe, exists := c.operations[id]
+	delete(c.operations, id)
+	c.mu.Unlock()
+	if exists {
+		unsubscribe()
+		c.send(id, gqlwsComplete, nil)
+	}
+}
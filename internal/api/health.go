@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const readinessCheckTimeout = time.Second * 5
+
+// readinessCheck is a single named check that must pass for the api to be ready
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context, db *sqlx.DB) error
+}
+
+func tableQueryableCheck(tableConfig TableConfig) readinessCheck {
+	return readinessCheck{
+		name: fmt.Sprintf("table %s", tableConfig.TableName),
+		check: func(ctx context.Context, db *sqlx.DB) error {
+			var exists bool
+			return db.GetContext(ctx, &exists, fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s LIMIT 1);", tableConfig.TableName))
+		},
+	}
+}
+
+var readinessChecks = []readinessCheck{
+	{
+		name: "postgres",
+		check: func(ctx context.Context, db *sqlx.DB) error {
+			return db.PingContext(ctx)
+		},
+	},
+	{
+		name: "postgis",
+		check: func(ctx context.Context, db *sqlx.DB) error {
+			var version string
+			return db.GetContext(ctx, &version, "SELECT PostGIS_Version();")
+		},
+	},
+	tableQueryableCheck(roomConfig),
+	tableQueryableCheck(buildingConfig),
+}
+
+// healthHandler serves the liveness and readiness endpoints
+type healthHandler struct {
+	db *sqlx.DB
+}
+
+func (h *healthHandler) serveHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealthStatus(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (h *healthHandler) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessCheckTimeout)
+	defer cancel()
+
+	status := http.StatusOK
+	results := map[string]string{}
+	for _, check := range readinessChecks {
+		if err := check.check(ctx, h.db); err != nil {
+			status = http.StatusServiceUnavailable
+			results[check.name] = err.Error()
+		} else {
+			results[check.name] = "ok"
+		}
+	}
+	writeHealthStatus(w, status, results)
+}
+
+func writeHealthStatus(w http.ResponseWriter, status int, body map[string]string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+const (
+	connectMinBackoff = time.Millisecond * 500
+	connectMaxBackoff = time.Second * 10
+)
+
+// waitForDB pings the database with exponential backoff until it responds or timeout elapses
+func waitForDB(ctx context.Context, db *sqlx.DB, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	backoff := connectMinBackoff
+	for {
+		err := db.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+		log.Printf("database not reachable yet, retrying in %s: %v", backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("database not reachable after %s: %v", timeout, err)
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > connectMaxBackoff {
+			backoff = connectMaxBackoff
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package api
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwtLeeway is the clock skew allowed when checking exp and nbf
+const jwtLeeway = time.Minute
+
+// jsonWebKey is an RSA or EC public key of a JWKS
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// verificationKey is a public key from a JWKS and the algorithms it may verify
+type verificationKey struct {
+	kid  string
+	algs []string
+	key  crypto.PublicKey
+}
+
+func decodeBigInt(encoded string) (*big.Int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(decoded), nil
+}
+
+// curveAlgs maps the supported curves to the only algorithm that signs with them
+var curveAlgs = map[string]string{"P-256": "ES256", "P-384": "ES384", "P-521": "ES512"}
+
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, known := curves[jwk.Crv]
+		if !known {
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %s", jwk.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", jwk.Kty)
+	}
+}
+
+// algorithms returns the algorithms the key may verify: those of its type (and curve), narrowed to its alg if it has one
+func (jwk jsonWebKey) algorithms() ([]string, error) {
+	var algs []string
+	switch jwk.Kty {
+	case "RSA":
+		algs = []string{"RS256", "RS384", "RS512"}
+	case "EC":
+		algs = []string{curveAlgs[jwk.Crv]}
+	}
+	if jwk.Alg == "" {
+		return algs, nil
+	}
+	if !containsString(algs, jwk.Alg) {
+		return nil, fmt.Errorf("alg %s doesn't match key type %s %s", jwk.Alg, jwk.Kty, jwk.Crv)
+	}
+	return []string{jwk.Alg}, nil
+}
+
+func loadJWKS(path string) ([]verificationKey, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &jwks); err != nil {
+		return nil, fmt.Errorf("invalid JWKS file %s: %v", path, err)
+	}
+	var keys []verificationKey
+	for i, jwk := range jwks.Keys {
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %d in JWKS file %s: %v", i, path, err)
+		}
+		algs, err := jwk.algorithms()
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %d in JWKS file %s: %v", i, path, err)
+		}
+		keys = append(keys, verificationKey{jwk.Kid, algs, key})
+	}
+	return keys, nil
+}
+
+// jwtAuthenticator authenticates bearer JWTs signed with a shared secret (HS*) or a key of a JWKS (RS*, ES*)
+type jwtAuthenticator struct {
+	secret     []byte
+	keys       []verificationKey
+	issuer     string
+	audience   string
+	rolesClaim string
+	// algs is the allow-list of algorithms, those of the secret and the keys
+	algs []string
+}
+
+func newJWTAuthenticator(config AuthConfig) (*jwtAuthenticator, error) {
+	authenticator := &jwtAuthenticator{
+		secret:     []byte(config.JWTSecret),
+		issuer:     config.JWTIssuer,
+		audience:   config.JWTAudience,
+		rolesClaim: config.RolesClaim,
+	}
+	if config.JWKSFile != "" {
+		keys, err := loadJWKS(config.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticator.keys = keys
+	}
+	authenticator.algs = authenticator.allowedAlgorithms()
+	return authenticator, nil
+}
+
+func (a *jwtAuthenticator) allowedAlgorithms() []string {
+	var algs []string
+	if len(a.secret) != 0 {
+		algs = append(algs, "HS256", "HS384", "HS512")
+	}
+	for _, key := range a.keys {
+		for _, alg := range key.algs {
+			if !containsString(algs, alg) {
+				algs = append(algs, alg)
+			}
+		}
+	}
+	return algs
+}
+
+func (a *jwtAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return nil, nil
+	}
+	identity, err := a.verify(token, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %v", err)
+	}
+	return identity, nil
+}
+
+// verify checks the token's signature and claims and returns the identity it holds
+func (a *jwtAuthenticator) verify(token string, now time.Time) (*Identity, error) {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(a.algs),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(jwtLeeway),
+		jwt.WithTimeFunc(func() time.Time { return now }),
+	}
+	if a.issuer != "" {
+		options = append(options, jwt.WithIssuer(a.issuer))
+	}
+	if a.audience != "" {
+		options = append(options, jwt.WithAudience(a.audience))
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := jwt.NewParser(options...).ParseWithClaims(token, claims, a.verificationKey); err != nil {
+		return nil, err
+	}
+	return a.identity(claims)
+}
+
+// verificationKey returns the secret or the JWKS keys that may verify the token: those with its kid (if it has one)
+// that are allowed to verify its alg
+func (a *jwtAuthenticator) verificationKey(token *jwt.Token) (interface{}, error) {
+	alg := token.Method.Alg()
+	if strings.HasPrefix(alg, "HS") {
+		if len(a.secret) == 0 {
+			return nil, fmt.Errorf("no secret for alg %s", alg)
+		}
+		return a.secret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	var keys jwt.VerificationKeySet
+	for _, key := range a.keys {
+		if (kid == "" || key.kid == kid) && containsString(key.algs, alg) {
+			keys.Keys = append(keys.Keys, key.key)
+		}
+	}
+	if len(keys.Keys) == 0 {
+		return nil, fmt.Errorf("no key for kid \"%s\" and alg %s", kid, alg)
+	}
+	return keys, nil
+}
+
+// identity returns the subject of verified claims and its known roles
+func (a *jwtAuthenticator) identity(claims jwt.MapClaims) (*Identity, error) {
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("missing subject")
+	}
+	identity := &Identity{Subject: subject}
+	for _, role := range claimStrings(claims[a.rolesClaim]) {
+		if isRole(role) {
+			identity.Roles = append(identity.Roles, role)
+		}
+	}
+	return identity, nil
+}
+
+// claimStrings returns the strings of a claim that is either an array of strings or a space separated string
+func claimStrings(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return strings.Fields(value)
+	case []interface{}:
+		var values []string
+		for _, element := range value {
+			if s, ok := element.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
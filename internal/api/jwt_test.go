@@ -0,0 +1,140 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func encodeBigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func newTestJWTAuthenticator(t *testing.T, secret string, keys ...jsonWebKey) *jwtAuthenticator {
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": keys})
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := ioutil.WriteFile(path, jwks, 0600); err != nil {
+		t.Fatal(err)
+	}
+	authenticator, err := newJWTAuthenticator(AuthConfig{JWTSecret: secret, JWKSFile: path, JWTIssuer: "andin", RolesClaim: "roles"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authenticator
+}
+
+func TestJWTVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaJWK := jsonWebKey{Kty: "RSA", Kid: "rsa", Alg: "RS256", N: encodeBigInt(rsaKey.N), E: encodeBigInt(big.NewInt(int64(rsaKey.E)))}
+	ecJWK := jsonWebKey{Kty: "EC", Kid: "ec", Crv: "P-256", X: encodeBigInt(ecKey.X), Y: encodeBigInt(ecKey.Y)}
+
+	now := time.Unix(1600000000, 0)
+	claims := func(extra jwt.MapClaims) jwt.MapClaims {
+		claims := jwt.MapClaims{"sub": "alice", "iss": "andin", "exp": now.Add(time.Hour).Unix(), "roles": []string{"surveyor"}}
+		for name, value := range extra {
+			if value == nil {
+				delete(claims, name)
+			} else {
+				claims[name] = value
+			}
+		}
+		return claims
+	}
+	sign := func(method jwt.SigningMethod, kid string, claims jwt.MapClaims, key interface{}) string {
+		token := jwt.NewWithClaims(method, claims)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	noneToken, _ := jwt.NewWithClaims(jwt.SigningMethodNone, claims(nil)).SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	withSecret := newTestJWTAuthenticator(t, "secret", rsaJWK, ecJWK)
+	withoutSecret := newTestJWTAuthenticator(t, "", rsaJWK, ecJWK)
+
+	tests := []struct {
+		name          string
+		authenticator *jwtAuthenticator
+		token         string
+		valid         bool
+	}{
+		{"HS256 with the secret", withSecret, sign(jwt.SigningMethodHS256, "", claims(nil), []byte("secret")), true},
+		{"HS256 with another secret", withSecret, sign(jwt.SigningMethodHS256, "", claims(nil), []byte("other")), false},
+		{"RS256 with the kid", withSecret, sign(jwt.SigningMethodRS256, "rsa", claims(nil), rsaKey), true},
+		{"RS256 without a kid", withSecret, sign(jwt.SigningMethodRS256, "", claims(nil), rsaKey), true},
+		{"ES256 with the kid", withoutSecret, sign(jwt.SigningMethodES256, "ec", claims(nil), ecKey), true},
+		{"missing exp", withSecret, sign(jwt.SigningMethodHS256, "", claims(jwt.MapClaims{"exp": nil}), []byte("secret")), false},
+		{"expired", withSecret, sign(jwt.SigningMethodHS256, "", claims(jwt.MapClaims{"exp": now.Add(-2 * jwtLeeway).Unix()}), []byte("secret")), false},
+		{"expired within the leeway", withSecret, sign(jwt.SigningMethodHS256, "", claims(jwt.MapClaims{"exp": now.Add(-jwtLeeway / 2).Unix()}), []byte("secret")), true},
+		{"not valid yet", withSecret, sign(jwt.SigningMethodHS256, "", claims(jwt.MapClaims{"nbf": now.Add(2 * jwtLeeway).Unix()}), []byte("secret")), false},
+		{"wrong issuer", withSecret, sign(jwt.SigningMethodHS256, "", claims(jwt.MapClaims{"iss": "other"}), []byte("secret")), false},
+		{"missing subject", withSecret, sign(jwt.SigningMethodHS256, "", claims(jwt.MapClaims{"sub": nil}), []byte("secret")), false},
+		{"alg none", withSecret, noneToken, false},
+		{"HS256 signed with the public key without a secret", withoutSecret, sign(jwt.SigningMethodHS256, "rsa", claims(nil), []byte(rsaJWK.N)), false},
+		{"RS512 with a key restricted to RS256", withSecret, sign(jwt.SigningMethodRS512, "rsa", claims(nil), rsaKey), false},
+		{"RS256 with the kid of the EC key", withSecret, sign(jwt.SigningMethodRS256, "ec", claims(nil), rsaKey), false},
+		{"RS256 with an unknown kid", withSecret, sign(jwt.SigningMethodRS256, "unknown", claims(nil), rsaKey), false},
+		{"ES384 with a P-256 key", withoutSecret, swapAlg(t, sign(jwt.SigningMethodES256, "ec", claims(nil), ecKey), "ES384"), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			identity, err := test.authenticator.verify(test.token, now)
+			if test.valid && err != nil {
+				t.Fatalf("valid token rejected: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Fatalf("invalid token accepted as %+v", identity)
+			}
+			if test.valid && (identity.Subject != "alice" || len(identity.Roles) != 1 || identity.Roles[0] != roleSurveyor) {
+				t.Errorf("identity = %+v", identity)
+			}
+		})
+	}
+}
+
+// swapAlg replaces the alg in the header of a signed token, keeping its signature
+func swapAlg(t *testing.T, token string, alg string) string {
+	parser := jwt.NewParser()
+	parsed, parts, err := parser.ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed.Header["alg"] = alg
+	header, _ := json.Marshal(parsed.Header)
+	return base64.RawURLEncoding.EncodeToString(header) + "." + parts[1] + "." + parts[2]
+}
+
+func TestJWKSRejectsMismatchedAlg(t *testing.T) {
+	tests := []jsonWebKey{
+		{Kty: "EC", Crv: "P-256", Alg: "ES384"},
+		{Kty: "EC", Crv: "P-384", Alg: "RS256"},
+		{Kty: "RSA", Alg: "HS256"},
+	}
+	for _, jwk := range tests {
+		if _, err := jwk.algorithms(); err == nil {
+			t.Errorf("%s %s key with alg %s accepted", jwk.Kty, jwk.Crv, jwk.Alg)
+		}
+	}
+}
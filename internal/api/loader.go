@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sync"
+
+	"github.com/graphql-go/handler"
+	"github.com/jmoiron/sqlx"
+)
+
+type loadersContextKey struct{}
+
+// loaders batches and caches getByID lookups for the duration of a single request
+type loaders struct {
+	db      *sqlx.DB
+	mu      sync.Mutex
+	byTable map[TableConfig]*loader
+}
+
+// loader batches the lookups for a single table (and selection of columns)
+type loader struct {
+	tableConfig TableConfig
+	elementType reflect.Type
+	pending     []int
+	cache       map[int]interface{}
+	// errs are the errors of the failed dispatches of ids, which every thunk waiting on them returns
+	errs map[int]error
+}
+
+func newLoaders(db *sqlx.DB) *loaders {
+	return &loaders{
+		db:      db,
+		byTable: map[TableConfig]*loader{},
+	}
+}
+
+func withLoaders(ctx context.Context, db *sqlx.DB) context.Context {
+	return context.WithValue(ctx, loadersContextKey{}, newLoaders(db))
+}
+
+// loadersFromContext returns the request's loaders, or fresh (unshared) ones if there are none
+func loadersFromContext(ctx context.Context, db *sqlx.DB) *loaders {
+	if ctx != nil {
+		if l, ok := ctx.Value(loadersContextKey{}).(*loaders); ok {
+			return l
+		}
+	}
+	return newLoaders(db)
+}
+
+// loaderHandler gives every graphql request its own set of loaders
+func loaderHandler(db *sqlx.DB, h *handler.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ContextHandler(withLoaders(r.Context(), db), w, r)
+	})
+}
+
+// loadByID queues id to be fetched from the table and returns a thunk that resolves to the element
+// (of the same type as element). All ids queued before the first thunk is called are fetched in one query.
+func loadByID(ctx context.Context, db *sqlx.DB, tableConfig TableConfig, id int, element interface{}) func() (interface{}, error) {
+	l := loadersFromContext(ctx, db)
+	l.mu.Lock()
+	tableLoader, exists := l.byTable[tableConfig]
+	if !exists {
+		tableLoader = &loader{
+			tableConfig: tableConfig,
+			elementType: reflect.TypeOf(element),
+			cache:       map[int]interface{}{},
+			errs:        map[int]error{},
+		}
+		l.byTable[tableConfig] = tableLoader
+	}
+	if _, cached := tableLoader.cache[id]; !cached {
+		tableLoader.pending = append(tableLoader.pending, id)
+	}
+	l.mu.Unlock()
+
+	return func() (interface{}, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if _, cached := tableLoader.cache[id]; !cached {
+			if _, failed := tableLoader.errs[id]; !failed {
+				tableLoader.dispatch(ctx, l.db)
+			}
+		}
+		if err, failed := tableLoader.errs[id]; failed {
+			return nil, err
+		}
+		element, exists := tableLoader.cache[id]
+		if !exists {
+			return nil, errNoElementWithID(tableConfig)
+		}
+		return element, nil
+	}
+}
+
+// dispatch fetches all pending ids in a single query, recording its error for all of them if it fails
+func (tableLoader *loader) dispatch(ctx context.Context, db *sqlx.DB) {
+	seen := make(map[int]bool, len(tableLoader.pending))
+	var ids []int
+	for _, id := range tableLoader.pending {
+		if _, cached := tableLoader.cache[id]; !seen[id] && !cached {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	tableLoader.pending = nil
+	if len(ids) == 0 {
+		return
+	}
+
+	elements := reflect.New(reflect.SliceOf(tableLoader.elementType))
+	if err := getByIDs(ctx, db, tableLoader.tableConfig, ids, elements.Interface()); err != nil {
+		for _, id := range ids {
+			tableLoader.errs[id] = err
+		}
+		return
+	}
+
+	for i := 0; i < elements.Elem().Len(); i++ {
+		element := elements.Elem().Index(i)
+		tableLoader.cache[int(element.FieldByName("ID").Int())] = element.Interface()
+	}
+}
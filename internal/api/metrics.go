@@ -0,0 +1,216 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/handler"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxOperationNames is the number of distinct operation names that get their own label value,
+// operation names are chosen by clients so later ones are counted as "other"
+const maxOperationNames = 500
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "andin_http_requests_total",
+		Help: "HTTP requests by handler, graphql operation name and status code.",
+	}, []string{"handler", "operation", "status"})
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "andin_http_request_duration_seconds",
+		Help:    "HTTP request latency by handler and graphql operation name.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"handler", "operation"})
+	resolverDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "andin_graphql_resolver_duration_seconds",
+		Help:    "GraphQL resolver latency by parent type and field.",
+		Buckets: []float64{.0001, .0005, .001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"type", "field"})
+	sqlQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "andin_sql_query_duration_seconds",
+		Help:    "SQL query latency by query kind and table.",
+		Buckets: []float64{.0005, .001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"query", "table"})
+	sqlQueryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "andin_sql_query_errors_total",
+		Help: "Failed SQL queries (not counting queries without rows) by query kind and table.",
+	}, []string{"query", "table"})
+	geocodeCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "andin_geocode_calls_total",
+		Help: "Geocoding provider calls by provider and result (found, not_found, failed, canceled or circuit_open).",
+	}, []string{"provider", "result"})
+	geocodeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "andin_geocode_duration_seconds",
+		Help:    "Geocoding provider latency by provider.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"provider"})
+	geocodeCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "andin_geocode_cache_lookups_total",
+		Help: "Geocode cache lookups by cache (memory or postgres) and result (hit or miss).",
+	}, []string{"cache", "result"})
+)
+
+// newMetricsRegistry returns a registry with the api's metrics, the go runtime and process metrics
+// and the connection pool stats of db
+func newMetricsRegistry(db *sqlx.DB) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewDBStatsCollector(db.DB, "andin"),
+		httpRequests,
+		httpRequestDuration,
+		resolverDuration,
+		sqlQueryDuration,
+		sqlQueryErrors,
+		geocodeCalls,
+		geocodeDuration,
+		geocodeCacheLookups,
+	)
+	return registry
+}
+
+func metricsHandler(registry *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// observeQuery starts timing a query, call the returned function with the query's error when it is done
+func observeQuery(query string, tableConfig TableConfig) func(error) {
+	start := time.Now()
+	return func(err error) {
+		sqlQueryDuration.WithLabelValues(query, tableConfig.TableName).Observe(time.Since(start).Seconds())
+		if err != nil && !isNoRows(err) {
+			sqlQueryErrors.WithLabelValues(query, tableConfig.TableName).Inc()
+		}
+	}
+}
+
+// operationNames limits the label values of operation names to the first maxOperationNames seen
+type operationNames struct {
+	mu    sync.Mutex
+	names map[string]bool
+}
+
+func (names *operationNames) label(name string) string {
+	if name == "" {
+		return "anonymous"
+	}
+	names.mu.Lock()
+	defer names.mu.Unlock()
+	if !names.names[name] {
+		if len(names.names) >= maxOperationNames {
+			return "other"
+		}
+		names.names[name] = true
+	}
+	return name
+}
+
+// operationName returns the name of the operation a graphql request executes: its operationName or
+// the name of the only operation in its document
+func operationName(r *http.Request) string {
+	requestBody(r)
+	options := handler.NewRequestOptions(r)
+	requestBody(r)
+
+	if options.OperationName != "" || options.Query == "" {
+		return options.OperationName
+	}
+	document, err := parser.Parse(parser.ParseParams{Source: options.Query})
+	if err != nil {
+		return ""
+	}
+	name := ""
+	for _, definition := range document.Definitions {
+		if operation, isOperation := definition.(*ast.OperationDefinition); isOperation {
+			if name != "" || operation.Name == nil {
+				return ""
+			}
+			name = operation.Name.Value
+		}
+	}
+	return name
+}
+
+// statusRecorder remembers the status code written to a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// httpMetricsHandler counts and times the requests to h, labelled with their graphql operation name
+// (which is also added to the request's span) if graphql is true
+func httpMetricsHandler(name string, graphql bool, h http.Handler) http.Handler {
+	names := &operationNames{names: map[string]bool{}}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		operation := ""
+		if graphql {
+			rawOperation := operationName(r)
+			operation = names.label(rawOperation)
+			span := trace.SpanFromContext(r.Context())
+			span.SetName(name + " " + operation)
+			span.SetAttributes(attribute.String("graphql.operation.name", rawOperation))
+		}
+		recorder := &statusRecorder{w, http.StatusOK}
+		h.ServeHTTP(recorder, r)
+		httpRequests.WithLabelValues(name, operation, strconv.Itoa(recorder.status)).Inc()
+		httpRequestDuration.WithLabelValues(name, operation).Observe(time.Since(start).Seconds())
+	})
+}
+
+// metricsExtension times every resolver
+type metricsExtension struct{}
+
+func (metricsExtension) Init(ctx context.Context, params *graphql.Params) context.Context {
+	return ctx
+}
+
+func (metricsExtension) Name() string {
+	return "metrics"
+}
+
+func (metricsExtension) ParseDidStart(ctx context.Context) (context.Context, graphql.ParseFinishFunc) {
+	return ctx, func(error) {}
+}
+
+func (metricsExtension) ValidationDidStart(ctx context.Context) (context.Context, graphql.ValidationFinishFunc) {
+	return ctx, func([]gqlerrors.FormattedError) {}
+}
+
+func (metricsExtension) ExecutionDidStart(ctx context.Context) (context.Context, graphql.ExecutionFinishFunc) {
+	return ctx, func(*graphql.Result) {}
+}
+
+func (metricsExtension) ResolveFieldDidStart(ctx context.Context, info *graphql.ResolveInfo) (context.Context, graphql.ResolveFieldFinishFunc) {
+	start := time.Now()
+	return ctx, func(interface{}, error) {
+		resolverDuration.WithLabelValues(info.ParentType.Name(), info.FieldName).Observe(time.Since(start).Seconds())
+	}
+}
+
+func (metricsExtension) HasResult() bool {
+	return false
+}
+
+func (metricsExtension) GetResult(ctx context.Context) interface{} {
+	return nil
+}
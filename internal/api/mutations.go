@@ -0,0 +1,185 @@
+package api
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// newUID returns a random (version 4) uuid for a new element
+func newUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// isUUID reports whether s is a uuid in its usual text form, e.g. 0b6d8e4e-5c1a-4e2f-9b7d-3f1c2a4b5c6d
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case i == 8 || i == 13 || i == 18 || i == 23:
+			if c != '-' {
+				return false
+			}
+		case !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'):
+			return false
+		}
+	}
+	return true
+}
+
+// elementVersion identifies the version of an element a mutation was based on
+type elementVersion struct {
+	uid     string
+	version int
+}
+
+// roomEdit are the room fields a mutation sets, unused fields are left as they are
+type roomEdit struct {
+	name         optionalNullableStringFilter
+	geometry     optionalStringFilter
+	level        optionalIntFilter
+	levelPostfix optionalNullableStringFilter
+	ref          optionalNullableStringFilter
+	building     optionalStringFilter
+}
+
+// buildingEdit are the building fields a mutation sets, unused fields are left as they are
+type buildingEdit struct {
+	name     optionalNullableStringFilter
+	geometry optionalStringFilter
+	address  *Address
+}
+
+func optionalStringArg(input map[string]interface{}, name string) optionalStringFilter {
+	arg := input[name]
+	if arg == nil {
+		return optionalStringFilter{}
+	}
+	return optionalStringFilter{true, arg.(string)}
+}
+
+// parseClearArg returns the (nullable) input fields listed in the input's clear argument. graphql-go drops
+// explicit nulls, so clearing a field has to be asked for by name.
+func parseClearArg(input map[string]interface{}) (map[string]bool, error) {
+	cleared := map[string]bool{}
+	clearArg, _ := input["clear"].([]interface{})
+	for _, field := range clearArg {
+		name := field.(string)
+		if input[name] != nil {
+			return nil, invalidArgumentError("clear", "<clear> cannot contain %s, which is also set", name)
+		}
+		cleared[name] = true
+	}
+	return cleared, nil
+}
+
+// optionalNullableStringArg returns the value of a nullable input field, null if it is cleared
+func optionalNullableStringArg(input map[string]interface{}, cleared map[string]bool, name string) optionalNullableStringFilter {
+	if cleared[name] {
+		return optionalNullableStringFilter{true, nil}
+	}
+	arg := input[name]
+	if arg == nil {
+		return optionalNullableStringFilter{}
+	}
+	value := arg.(string)
+	return optionalNullableStringFilter{true, &value}
+}
+
+func parseSurveyArg(input map[string]interface{}) (string, error) {
+	survey := strings.TrimSpace(input["survey"].(string))
+	if survey == "" {
+		return "", invalidArgumentError("survey", "<survey> cannot be empty")
+	}
+	return survey, nil
+}
+
+func parseElementVersionArgs(input map[string]interface{}) elementVersion {
+	return elementVersion{input["uid"].(string), input["version"].(int)}
+}
+
+func parseCreateSurveyArgs(args map[string]interface{}) (Survey, error) {
+	survey := Survey{UID: newUID()}
+	survey.Surveyor = strings.TrimSpace(args["surveyor"].(string))
+	if survey.Surveyor == "" {
+		return survey, invalidArgumentError("surveyor", "<surveyor> cannot be empty")
+	}
+	if externalArg := args["external"]; externalArg != nil {
+		survey.External = externalArg.(bool)
+	}
+	return survey, nil
+}
+
+func parseRoomEditArgs(input map[string]interface{}) (roomEdit, error) {
+	cleared, err := parseClearArg(input)
+	if err != nil {
+		return roomEdit{}, err
+	}
+	edit := roomEdit{
+		name:         optionalNullableStringArg(input, cleared, "name"),
+		geometry:     optionalStringArg(input, "geometry"),
+		levelPostfix: optionalNullableStringArg(input, cleared, "levelPostfix"),
+		ref:          optionalNullableStringArg(input, cleared, "ref"),
+		building:     optionalStringArg(input, "building"),
+	}
+	if levelArg := input["level"]; levelArg != nil {
+		edit.level = optionalIntFilter{true, levelArg.(int)}
+	}
+	return edit, nil
+}
+
+func parseBuildingEditArgs(input map[string]interface{}) (buildingEdit, error) {
+	cleared, err := parseClearArg(input)
+	if err != nil {
+		return buildingEdit{}, err
+	}
+	edit := buildingEdit{
+		name:     optionalNullableStringArg(input, cleared, "name"),
+		geometry: optionalStringArg(input, "geometry"),
+	}
+	if addressArg := input["address"]; addressArg != nil {
+		var address Address
+		mapstructure.Decode(addressArg, &address)
+		edit.address = &address
+	}
+	return edit, nil
+}
+
+// columnValues are columns and the sql expressions (with numbered args) to set them to
+type columnValues struct {
+	columns []string
+	values  []string
+	args    []interface{}
+}
+
+// param adds an arg and returns its placeholder
+func (c *columnValues) param(arg interface{}) string {
+	c.args = append(c.args, arg)
+	return fmt.Sprintf("$%d", len(c.args))
+}
+
+func (c *columnValues) set(column string, value string) {
+	c.columns = append(c.columns, column)
+	c.values = append(c.values, value)
+}
+
+func (c *columnValues) insertQuery(tableConfig TableConfig) string {
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) RETURNING id;", tableConfig.TableName, strings.Join(c.columns, ", "), strings.Join(c.values, ", "))
+}
+
+// updateQuery returns a query that updates the element with the id given as the next arg
+func (c *columnValues) updateQuery(tableConfig TableConfig, id int) string {
+	sets := make([]string, len(c.columns))
+	for i, column := range c.columns {
+		sets[i] = fmt.Sprintf("%s = %s", column, c.values[i])
+	}
+	return fmt.Sprintf("UPDATE %s SET %s WHERE id = %s;", tableConfig.TableName, strings.Join(sets, ", "), c.param(id))
+}
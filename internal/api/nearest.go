@@ -0,0 +1,72 @@
+package api
+
+import (
+	"sort"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+const defaultNearestK = 10
+const maxNearestK = 100
+
+// NearestKind defines what kind of elements to find the nearest of
+type NearestKind int
+
+// NearestKind enum
+const (
+	NearestRoom     NearestKind = 0
+	NearestBuilding NearestKind = 1
+)
+
+type nearestFilterConfig struct {
+	coordinates Coordinates
+	k           int
+	kind        optionalNearestKindFilter
+}
+
+func parseNearestFilterArgs(args map[string]interface{}) (nearestFilterConfig, error) {
+	var config nearestFilterConfig
+	mapstructure.Decode(args["coordinates"], &config.coordinates)
+
+	config.k = defaultNearestK
+	if kArg := args["k"]; kArg != nil {
+		config.k = kArg.(int)
+	}
+	if config.k < 1 || config.k > maxNearestK {
+		return config, invalidArgumentError("k", "<k> (%d) must be between 1 and %d", config.k, maxNearestK)
+	}
+
+	if kindArg := args["kind"]; kindArg != nil {
+		config.kind = optionalNearestKindFilter{true, NearestKind(kindArg.(int))}
+	}
+
+	return config, nil
+}
+
+// includes reports whether elements of kind are searched
+func (filterConfig nearestFilterConfig) includes(kind NearestKind) bool {
+	return !filterConfig.kind.use || filterConfig.kind.filter == kind
+}
+
+// NearestResult is a Room or Building near the coordinates, with its distance in meters
+type NearestResult struct {
+	Item     interface{}
+	Distance float64
+}
+
+// mergeNearestResults returns the k nearest of the rooms and buildings, nearest first
+func mergeNearestResults(filterConfig nearestFilterConfig, rooms []FilteredRoom, buildings []FilteredBuilding) []NearestResult {
+	var results []NearestResult
+	for _, room := range rooms {
+		results = append(results, NearestResult{room.Room, *room.Distance})
+	}
+	for _, building := range buildings {
+		results = append(results, NearestResult{building.Building, *building.Distance})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Distance < results[j].Distance })
+	if len(results) > filterConfig.k {
+		results = results[:filterConfig.k]
+	}
+	return results
+}
@@ -0,0 +1,542 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const ogcDefaultLimit = 10
+const ogcMaxLimit = 1000
+
+const ogcCRS84 = "http://www.opengis.net/def/crs/OGC/1.3/CRS84"
+
+var ogcConformanceClasses = []string{
+	"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/core",
+	"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/geojson",
+}
+
+// ogcItemsParams are the query parameters of /items besides the property filters
+var ogcItemsParams = []string{"bbox", "limit", "offset", "f"}
+
+// ogcValueKind is the type of the column a feature id or property filter value is compared with
+type ogcValueKind int
+
+// ogcValueKind enum
+const (
+	ogcString ogcValueKind = 0
+	ogcInt    ogcValueKind = 1
+	ogcUUID   ogcValueKind = 2
+)
+
+// parse returns raw as a value of the kind, so it can be compared with the column without a cast
+func (kind ogcValueKind) parse(raw string) (interface{}, error) {
+	switch kind {
+	case ogcInt:
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("must be an integer")
+		}
+		return parsed, nil
+	case ogcUUID:
+		if !isUUID(raw) {
+			return nil, fmt.Errorf("must be a uuid")
+		}
+	}
+	return raw, nil
+}
+
+// ogcPropertyFilter is a query parameter that filters the features of a collection on one of their properties
+type ogcPropertyFilter struct {
+	property string
+	// condition is the sql condition with a %s placeholder for the value
+	condition string
+	kind      ogcValueKind
+}
+
+// ogcCollection is a table served as a feature collection
+type ogcCollection struct {
+	id          string
+	title       string
+	description string
+	tableConfig TableConfig
+	// idColumn identifies features in urls
+	idColumn string
+	idKind   ogcValueKind
+	// geometry is the sql expression of a feature's geometry
+	geometry string
+	// extraColumns are selected besides the columns of tableConfig
+	extraColumns string
+	filters      []ogcPropertyFilter
+	// features runs a query selecting the columns of featureConfig and returns its rows as features
+	features func(ctx context.Context, db *sqlx.DB, q string, args []interface{}) ([]ogcFeature, error)
+}
+
+// featureConfig returns a config that selects the columns of the collection's tableConfig, its
+// GeoJSON geometry and its extra columns
+func (collection ogcCollection) featureConfig() TableConfig {
+	return TableConfig{
+		TableName:         collection.tableConfig.TableName,
+		ElementName:       collection.tableConfig.ElementName,
+		ElementNamePlural: collection.tableConfig.ElementNamePlural,
+		Columns:           fmt.Sprintf("%s, ST_AsGeoJSON(%s) AS geojson%s", collection.tableConfig.Columns, collection.geometry, collection.extraColumns),
+	}
+}
+
+type ogcLink struct {
+	Href  string `json:"href"`
+	Rel   string `json:"rel"`
+	Type  string `json:"type,omitempty"`
+	Title string `json:"title,omitempty"`
+}
+
+// ogcFeature is a GeoJSON feature, geometry is nil for features without one
+type ogcFeature struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id"`
+	Geometry   json.RawMessage        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+	Links      []ogcLink              `json:"links,omitempty"`
+}
+
+func newOGCFeature(id string, geoJSON *string, properties map[string]interface{}) ogcFeature {
+	geometry := json.RawMessage("null")
+	if geoJSON != nil {
+		geometry = json.RawMessage(*geoJSON)
+	}
+	return ogcFeature{"Feature", id, geometry, properties, nil}
+}
+
+type ogcRoom struct {
+	Room
+	GeoJSON     *string
+	BuildingUID string `db:"building_uid"`
+}
+
+type ogcBuilding struct {
+	Building
+	GeoJSON *string
+}
+
+type ogcAddress struct {
+	Address
+	GeoJSON *string
+}
+
+var ogcCollections = []ogcCollection{
+	{
+		id:          "buildings",
+		title:       "Buildings",
+		description: "Building outlines",
+		tableConfig: buildingConfig,
+		idColumn:    "uid",
+		idKind:      ogcUUID,
+		geometry:    "geometry",
+		filters: []ogcPropertyFilter{
+			{"name", "name = %s", ogcString},
+			{"address", "address = %s", ogcInt},
+		},
+		features: func(ctx context.Context, db *sqlx.DB, q string, args []interface{}) ([]ogcFeature, error) {
+			var buildings []ogcBuilding
+			if err := db.SelectContext(ctx, &buildings, q, args...); err != nil {
+				return nil, err
+			}
+			features := make([]ogcFeature, 0, len(buildings))
+			for _, building := range buildings {
+				features = append(features, newOGCFeature(building.UID, building.GeoJSON, map[string]interface{}{
+					"name":    building.Name,
+					"address": building.Address,
+					"version": building.Version,
+				}))
+			}
+			return features, nil
+		},
+	},
+	{
+		id:           "rooms",
+		title:        "Rooms",
+		description:  "Room outlines, on the level of the building they are on",
+		tableConfig:  roomConfig,
+		idColumn:     "uid",
+		idKind:       ogcUUID,
+		geometry:     "geometry",
+		extraColumns: ", (SELECT uid FROM building WHERE building.id = room.building) AS building_uid",
+		filters: []ogcPropertyFilter{
+			{"level", "level = %s", ogcInt},
+			{"levelPostfix", "level_postfix = %s", ogcString},
+			{"name", "name = %s", ogcString},
+			{"ref", "ref = %s", ogcString},
+			{"building", "building = (SELECT id FROM building WHERE uid = %s)", ogcUUID},
+		},
+		features: func(ctx context.Context, db *sqlx.DB, q string, args []interface{}) ([]ogcFeature, error) {
+			var rooms []ogcRoom
+			if err := db.SelectContext(ctx, &rooms, q, args...); err != nil {
+				return nil, err
+			}
+			features := make([]ogcFeature, 0, len(rooms))
+			for _, room := range rooms {
+				features = append(features, newOGCFeature(room.UID, room.GeoJSON, map[string]interface{}{
+					"name":         room.Name,
+					"level":        room.Level,
+					"levelPostfix": room.LevelPostfix,
+					"ref":          room.Ref,
+					"building":     room.BuildingUID,
+					"version":      room.Version,
+				}))
+			}
+			return features, nil
+		},
+	},
+	{
+		id:          "addresses",
+		title:       "Addresses",
+		description: "Addresses of buildings, located on (a point on) the first building with the address",
+		tableConfig: addressConfig,
+		idColumn:    "id",
+		idKind:      ogcInt,
+		geometry:    "(SELECT ST_PointOnSurface(geometry) FROM building WHERE building.address = address.id ORDER BY building.id LIMIT 1)",
+		filters: []ogcPropertyFilter{
+			{"locality", "locality = %s", ogcString},
+			{"region", "region = %s", ogcString},
+			{"postcode", "postcode = %s", ogcString},
+			{"country", "country = %s", ogcString},
+		},
+		features: func(ctx context.Context, db *sqlx.DB, q string, args []interface{}) ([]ogcFeature, error) {
+			var addresses []ogcAddress
+			if err := db.SelectContext(ctx, &addresses, q, args...); err != nil {
+				return nil, err
+			}
+			features := make([]ogcFeature, 0, len(addresses))
+			for _, address := range addresses {
+				features = append(features, newOGCFeature(strconv.Itoa(address.ID), address.GeoJSON, map[string]interface{}{
+					"free":     address.Free,
+					"locality": address.Locality,
+					"region":   address.Region,
+					"postcode": address.Postcode,
+					"country":  address.Country,
+				}))
+			}
+			return features, nil
+		},
+	},
+}
+
+func findOGCCollection(id string) (ogcCollection, bool) {
+	for _, collection := range ogcCollections {
+		if collection.id == id {
+			return collection, true
+		}
+	}
+	return ogcCollection{}, false
+}
+
+// ogcPropertyValue is the value of a property filter query parameter
+type ogcPropertyValue struct {
+	filter ogcPropertyFilter
+	value  interface{}
+}
+
+// ogcItemsFilter is the requested page of the features of a collection, bbox is nil to not filter on it
+type ogcItemsFilter struct {
+	bbox       []float64
+	limit      int
+	offset     int
+	properties []ogcPropertyValue
+}
+
+func parseOGCItemsParams(collection ogcCollection, values url.Values) (ogcItemsFilter, error) {
+	filter := ogcItemsFilter{limit: ogcDefaultLimit}
+
+	for name := range values {
+		known := false
+		for _, param := range ogcItemsParams {
+			known = known || name == param
+		}
+		for _, propertyFilter := range collection.filters {
+			known = known || name == propertyFilter.property
+		}
+		if !known {
+			return filter, fmt.Errorf("unknown query parameter %s", name)
+		}
+	}
+
+	if f := values.Get("f"); f != "" && f != "json" && f != "geojson" {
+		return filter, fmt.Errorf("unsupported format %s, only json is supported", f)
+	}
+
+	if bboxParam := values.Get("bbox"); bboxParam != "" {
+		parts := strings.Split(bboxParam, ",")
+		if len(parts) != 4 && len(parts) != 6 {
+			return filter, fmt.Errorf("bbox (%s) must have 4 or 6 numbers", bboxParam)
+		}
+		var numbers []float64
+		for _, part := range parts {
+			number, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil {
+				return filter, fmt.Errorf("bbox (%s) must have 4 or 6 numbers", bboxParam)
+			}
+			numbers = append(numbers, number)
+		}
+		// Ignore the heights of a 3D bbox
+		if len(numbers) == 6 {
+			numbers = []float64{numbers[0], numbers[1], numbers[3], numbers[4]}
+		}
+		filter.bbox = numbers
+	}
+
+	if limitParam := values.Get("limit"); limitParam != "" {
+		limit, err := strconv.Atoi(limitParam)
+		if err != nil || limit < 1 {
+			return filter, fmt.Errorf("limit (%s) must be a positive integer", limitParam)
+		}
+		if limit > ogcMaxLimit {
+			limit = ogcMaxLimit
+		}
+		filter.limit = limit
+	}
+
+	if offsetParam := values.Get("offset"); offsetParam != "" {
+		offset, err := strconv.Atoi(offsetParam)
+		if err != nil || offset < 0 {
+			return filter, fmt.Errorf("offset (%s) must be a non-negative integer", offsetParam)
+		}
+		filter.offset = offset
+	}
+
+	for _, propertyFilter := range collection.filters {
+		raw, exists := values[propertyFilter.property]
+		if !exists {
+			continue
+		}
+		value, err := propertyFilter.kind.parse(raw[0])
+		if err != nil {
+			return filter, fmt.Errorf("%s (%s) %v", propertyFilter.property, raw[0], err)
+		}
+		filter.properties = append(filter.properties, ogcPropertyValue{propertyFilter, value})
+	}
+
+	return filter, nil
+}
+
+// ogcHandler serves the collections over OGC API - Features (Part 1: Core) with GeoJSON responses
+type ogcHandler struct {
+	db *sqlx.DB
+}
+
+// baseURL returns the scheme and host the request was made to, respecting reverse proxies
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	host := r.Host
+	if forwardedHost := r.Header.Get("X-Forwarded-Host"); forwardedHost != "" {
+		host = forwardedHost
+	}
+	return fmt.Sprintf("%s://%s", scheme, host)
+}
+
+func (h *ogcHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeOGCException(w, http.StatusMethodNotAllowed, "MethodNotAllowed", fmt.Sprintf("method %s is not allowed", r.Method))
+		return
+	}
+	if err := requireRole(r.Context(), roleReader); err != nil {
+		writeOGCException(w, err.(authError).status(), err.(authError).code, err.Error())
+		return
+	}
+
+	base := baseURL(r)
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.URL.Path == "/":
+		h.serveLandingPage(w, base)
+	case len(parts) == 1 && parts[0] == "conformance":
+		writeOGCResponse(w, "application/json", map[string]interface{}{"conformsTo": ogcConformanceClasses})
+	case len(parts) == 1 && parts[0] == "collections":
+		h.serveCollections(w, r, base)
+	case len(parts) >= 2 && len(parts) <= 4 && parts[0] == "collections":
+		collection, exists := findOGCCollection(parts[1])
+		if !exists {
+			writeOGCException(w, http.StatusNotFound, "NotFound", fmt.Sprintf("no collection %s", parts[1]))
+			return
+		}
+		switch {
+		case len(parts) == 2:
+			h.serveCollection(w, r, base, collection)
+		case parts[2] != "items":
+			writeOGCException(w, http.StatusNotFound, "NotFound", fmt.Sprintf("no resource %s", r.URL.Path))
+		case len(parts) == 3:
+			h.serveItems(w, r, base, collection)
+		default:
+			h.serveItem(w, r, base, collection, parts[3])
+		}
+	default:
+		writeOGCException(w, http.StatusNotFound, "NotFound", fmt.Sprintf("no resource %s", r.URL.Path))
+	}
+}
+
+func (h *ogcHandler) serveLandingPage(w http.ResponseWriter, base string) {
+	writeOGCResponse(w, "application/json", map[string]interface{}{
+		"title":       "andin",
+		"description": "Indoor rooms and buildings, also available over GraphQL at /graphql",
+		"links": []ogcLink{
+			{base + "/", "self", "application/json", "This document"},
+			{base + "/conformance", "conformance", "application/json", "Conformance classes"},
+			{base + "/collections", "data", "application/json", "Collections"},
+		},
+	})
+}
+
+func (h *ogcHandler) collectionMetadata(ctx context.Context, base string, collection ogcCollection) (map[string]interface{}, error) {
+	collectionURL := fmt.Sprintf("%s/collections/%s", base, collection.id)
+	metadata := map[string]interface{}{
+		"id":          collection.id,
+		"title":       collection.title,
+		"description": collection.description,
+		"itemType":    "feature",
+		"crs":         []string{ogcCRS84},
+		"links": []ogcLink{
+			{collectionURL, "self", "application/json", collection.title},
+			{collectionURL + "/items", "items", "application/geo+json", collection.title},
+		},
+	}
+	extent, err := getOGCExtent(ctx, h.db, collection)
+	if err != nil {
+		return nil, err
+	}
+	if extent != nil {
+		metadata["extent"] = map[string]interface{}{
+			"spatial": map[string]interface{}{"bbox": [][]float64{extent}, "crs": ogcCRS84},
+		}
+	}
+	return metadata, nil
+}
+
+func (h *ogcHandler) serveCollections(w http.ResponseWriter, r *http.Request, base string) {
+	var collections []map[string]interface{}
+	for _, collection := range ogcCollections {
+		metadata, err := h.collectionMetadata(r.Context(), base, collection)
+		if err != nil {
+			writeOGCServerError(w, err)
+			return
+		}
+		collections = append(collections, metadata)
+	}
+	writeOGCResponse(w, "application/json", map[string]interface{}{
+		"collections": collections,
+		"links": []ogcLink{
+			{base + "/collections", "self", "application/json", "Collections"},
+		},
+	})
+}
+
+func (h *ogcHandler) serveCollection(w http.ResponseWriter, r *http.Request, base string, collection ogcCollection) {
+	metadata, err := h.collectionMetadata(r.Context(), base, collection)
+	if err != nil {
+		writeOGCServerError(w, err)
+		return
+	}
+	writeOGCResponse(w, "application/json", metadata)
+}
+
+func (h *ogcHandler) serveItems(w http.ResponseWriter, r *http.Request, base string, collection ogcCollection) {
+	values := r.URL.Query()
+	filter, err := parseOGCItemsParams(collection, values)
+	if err != nil {
+		writeOGCException(w, http.StatusBadRequest, "InvalidParameterValue", err.Error())
+		return
+	}
+
+	features, err := getOGCFeatures(r.Context(), h.db, collection, filter)
+	if err != nil {
+		writeOGCServerError(w, err)
+		return
+	}
+	matched, err := countOGCFeatures(r.Context(), h.db, collection, filter)
+	if err != nil {
+		writeOGCServerError(w, err)
+		return
+	}
+
+	itemsURL := fmt.Sprintf("%s/collections/%s/items", base, collection.id)
+	pageLink := func(offset int, rel string) ogcLink {
+		values.Set("offset", strconv.Itoa(offset))
+		values.Set("limit", strconv.Itoa(filter.limit))
+		return ogcLink{itemsURL + "?" + values.Encode(), rel, "application/geo+json", ""}
+	}
+	links := []ogcLink{
+		pageLink(filter.offset, "self"),
+		{fmt.Sprintf("%s/collections/%s", base, collection.id), "collection", "application/json", collection.title},
+	}
+	if filter.offset+len(features) < matched {
+		links = append(links, pageLink(filter.offset+filter.limit, "next"))
+	}
+	if filter.offset > 0 {
+		previous := filter.offset - filter.limit
+		if previous < 0 {
+			previous = 0
+		}
+		links = append(links, pageLink(previous, "prev"))
+	}
+
+	writeOGCResponse(w, "application/geo+json", map[string]interface{}{
+		"type":           "FeatureCollection",
+		"features":       features,
+		"links":          links,
+		"numberMatched":  matched,
+		"numberReturned": len(features),
+		"timeStamp":      time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+func (h *ogcHandler) serveItem(w http.ResponseWriter, r *http.Request, base string, collection ogcCollection, id string) {
+	// A malformed id can't identify a feature
+	parsedID, err := collection.idKind.parse(id)
+	var feature *ogcFeature
+	if err == nil {
+		feature, err = getOGCFeature(r.Context(), h.db, collection, parsedID)
+		if err != nil {
+			writeOGCServerError(w, err)
+			return
+		}
+	}
+	if feature == nil {
+		writeOGCException(w, http.StatusNotFound, "NotFound", fmt.Sprintf("found no %s with id %s", collection.tableConfig.elementName(), id))
+		return
+	}
+	collectionURL := fmt.Sprintf("%s/collections/%s", base, collection.id)
+	feature.Links = []ogcLink{
+		{fmt.Sprintf("%s/items/%s", collectionURL, url.PathEscape(id)), "self", "application/geo+json", ""},
+		{collectionURL, "collection", "application/json", collection.title},
+	}
+	writeOGCResponse(w, "application/geo+json", feature)
+}
+
+func writeOGCResponse(w http.ResponseWriter, contentType string, body interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeOGCException responds with an OGC exception
+func writeOGCException(w http.ResponseWriter, status int, code string, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"code": code, "description": description})
+}
+
+func writeOGCServerError(w http.ResponseWriter, err error) {
+	log.Printf("error serving OGC API request: %v", err)
+	writeOGCException(w, http.StatusInternalServerError, "ServerError", "internal server error")
+}
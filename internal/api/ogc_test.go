@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestOGCInvalidValues checks that malformed ids and filter values are rejected before they reach the database
+func TestOGCInvalidValues(t *testing.T) {
+	h := &ogcHandler{}
+
+	tests := []struct {
+		url    string
+		status int
+	}{
+		{"/collections/buildings/items/not-a-uuid", http.StatusNotFound},
+		{"/collections/rooms/items/0b6d8e4e-5c1a-4e2f-9b7d", http.StatusNotFound},
+		{"/collections/addresses/items/0b6d8e4e-5c1a-4e2f-9b7d-3f1c2a4b5c6d", http.StatusNotFound},
+		{"/collections/rooms/items?building=not-a-uuid", http.StatusBadRequest},
+		{"/collections/rooms/items?level=first", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, test.url, nil)
+			h.ServeHTTP(w, r.WithContext(withIdentity(r.Context(), &Identity{Roles: []string{roleReader}})))
+			if w.Code != test.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, test.status, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestIsUUID(t *testing.T) {
+	tests := map[string]bool{
+		"0b6d8e4e-5c1a-4e2f-9b7d-3f1c2a4b5c6d": true,
+		"0B6D8E4E-5C1A-4E2F-9B7D-3F1C2A4B5C6D": true,
+		newUID():                               true,
+		"0b6d8e4e5c1a4e2f9b7d3f1c2a4b5c6d":     false,
+		"0b6d8e4e-5c1a-4e2f-9b7d-3f1c2a4b5c6g": false,
+		"0b6d8e4e-5c1a-4e2f-9b7d-3f1c2a4b5c6":  false,
+		"":                                     false,
+	}
+	for s, valid := range tests {
+		if isUUID(s) != valid {
+			t.Errorf("isUUID(%q) = %t, want %t", s, !valid, valid)
+		}
+	}
+}
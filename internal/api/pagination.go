@@ -0,0 +1,199 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+const defaultPageSize = 50
+const maxPageSize = 500
+
+// Sort orders a page can be keyed on, besides the element id that breaks ties
+const (
+	pageSortID       = "ID"
+	pageSortDistance = "DISTANCE"
+	pageSortArea     = "AREA"
+)
+
+// pageCursor is the position of an element in a sort order
+type pageCursor struct {
+	Sort  string   `json:"s"`
+	Value *float64 `json:"v,omitempty"`
+	ID    int      `json:"id"`
+}
+
+func (cursor pageCursor) encode() string {
+	encoded, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+func decodePageCursor(argument string, encoded string, sort string) (*pageCursor, error) {
+	var cursor pageCursor
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err == nil {
+		err = json.Unmarshal(decoded, &cursor)
+	}
+	if err != nil {
+		return nil, invalidArgumentError(argument, "invalid cursor (%s)", encoded)
+	}
+	if cursor.Sort != sort {
+		return nil, invalidArgumentError(argument, "cursor (%s) was created for a different sort order (%s), cannot use it with %s", encoded, cursor.Sort, sort)
+	}
+	if (cursor.Value == nil) != (sort == pageSortID) {
+		return nil, invalidArgumentError(argument, "invalid cursor (%s)", encoded)
+	}
+	return &cursor, nil
+}
+
+// pageConfig is a requested page of a list ordered by sort and then by id
+type pageConfig struct {
+	sort     string
+	size     int
+	backward bool
+	after    *pageCursor
+	before   *pageCursor
+}
+
+func parsePageArgs(args map[string]interface{}, sort string) (pageConfig, error) {
+	page := pageConfig{sort: sort, size: defaultPageSize}
+
+	firstArg := args["first"]
+	lastArg := args["last"]
+	if firstArg != nil && lastArg != nil {
+		return page, invalidArgumentError("last", "cannot use both <first> and <last> at the same time")
+	}
+	if firstArg != nil {
+		page.size = firstArg.(int)
+	}
+	if lastArg != nil {
+		page.size = lastArg.(int)
+		page.backward = true
+	}
+	if page.size < 0 || page.size > maxPageSize {
+		sizeArg := "first"
+		if page.backward {
+			sizeArg = "last"
+		}
+		return page, invalidArgumentError(sizeArg, "<first> or <last> (%d) must be between 0 and %d", page.size, maxPageSize)
+	}
+
+	var err error
+	if afterArg := args["after"]; afterArg != nil {
+		if page.after, err = decodePageCursor("after", afterArg.(string), sort); err != nil {
+			return page, err
+		}
+	}
+	if beforeArg := args["before"]; beforeArg != nil {
+		if page.before, err = decodePageCursor("before", beforeArg.(string), sort); err != nil {
+			return page, err
+		}
+	}
+	if !page.backward && page.before != nil && page.after == nil && firstArg == nil {
+		// Only before: take the elements right before the cursor
+		page.backward = true
+	}
+
+	return page, nil
+}
+
+// keyset returns the sql that limits a query (with an id and optionally sortColumn column) to the page
+// and the args it needs, appended to args
+func (page pageConfig) keyset(sortColumn string, args []interface{}) (string, []interface{}) {
+	keyColumns := "id"
+	if sortColumn != "" {
+		keyColumns = fmt.Sprintf("(%s, id)", sortColumn)
+	}
+	keyValue := func(cursor *pageCursor) string {
+		if cursor.Value == nil {
+			args = append(args, cursor.ID)
+			return fmt.Sprintf("$%d", len(args))
+		}
+		args = append(args, *cursor.Value, cursor.ID)
+		return fmt.Sprintf("($%d, $%d)", len(args)-1, len(args))
+	}
+
+	q := ""
+	if page.after != nil {
+		q += fmt.Sprintf("AND %s > %s ", keyColumns, keyValue(page.after))
+	}
+	if page.before != nil {
+		q += fmt.Sprintf("AND %s < %s ", keyColumns, keyValue(page.before))
+	}
+
+	direction := "ASC"
+	if page.backward {
+		direction = "DESC"
+	}
+	if sortColumn != "" {
+		q += fmt.Sprintf("ORDER BY %s %s, id %s ", sortColumn, direction, direction)
+	} else {
+		q += fmt.Sprintf("ORDER BY id %s ", direction)
+	}
+
+	// One extra element tells whether there is a next (or previous) page
+	q += fmt.Sprintf("LIMIT %d", page.size+1)
+	return q, args
+}
+
+// PageInfo is a relay connection's page info
+type PageInfo struct {
+	HasNextPage     bool
+	HasPreviousPage bool
+	StartCursor     *string
+	EndCursor       *string
+}
+
+// Edge is an element of a relay connection
+type Edge struct {
+	Node   interface{}
+	Cursor string
+}
+
+// Connection is a page of a list in relay connection form
+type Connection struct {
+	Edges    []Edge
+	PageInfo PageInfo
+	count    func() (int, error)
+}
+
+// connection builds a Connection from the (size + 1) elements fetched with keyset. keyOf returns the sort value
+// (nil when sorted on id) and id of an element, count the total number of elements in the list.
+func (page pageConfig) connection(elements interface{}, keyOf func(element interface{}) (*float64, int), count func() (int, error)) Connection {
+	elementsVal := reflect.ValueOf(elements)
+	n := elementsVal.Len()
+	hasMore := n > page.size
+	if hasMore {
+		n = page.size
+	}
+
+	edges := make([]Edge, n)
+	for i := 0; i < n; i++ {
+		element := elementsVal.Index(i).Interface()
+		value, id := keyOf(element)
+		edge := Edge{element, pageCursor{page.sort, value, id}.encode()}
+		if page.backward {
+			edges[n-1-i] = edge
+		} else {
+			edges[i] = edge
+		}
+	}
+
+	pageInfo := PageInfo{
+		HasNextPage:     hasMore,
+		HasPreviousPage: page.after != nil,
+	}
+	if page.backward {
+		pageInfo = PageInfo{
+			HasNextPage:     page.before != nil,
+			HasPreviousPage: hasMore,
+		}
+	}
+	if n > 0 {
+		pageInfo.StartCursor = &edges[0].Cursor
+		pageInfo.EndCursor = &edges[n-1].Cursor
+	}
+
+	return Connection{edges, pageInfo, count}
+}
@@ -0,0 +1,297 @@
+package api
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/graphql-go/handler"
+	"github.com/jmoiron/sqlx"
+)
+
+// Error codes of persistedQueryError, the messages of the first two are what apollo clients look for
+const (
+	errCodePersistedQueryNotFound     = "PERSISTED_QUERY_NOT_FOUND"
+	errCodePersistedQueryNotSupported = "PERSISTED_QUERY_NOT_SUPPORTED"
+	errCodePersistedQueryNotAllowed   = "PERSISTED_QUERY_NOT_ALLOWED"
+	errCodePersistedQueryHashMismatch = "PERSISTED_QUERY_HASH_MISMATCH"
+)
+
+// persistedQueryError is an error with a code that is reported in the graphql error's extensions
+type persistedQueryError struct {
+	code    string
+	message string
+}
+
+func (err persistedQueryError) Error() string {
+	return err.message
+}
+
+func (err persistedQueryError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": err.code}
+}
+
+// PersistedQueryStore holds the documents registered by clients by their sha256 hash
+type PersistedQueryStore interface {
+	// Get returns false (and no error) if there is no document with hash
+	Get(ctx context.Context, hash string) (string, bool, error)
+	Put(ctx context.Context, hash string, query string) error
+}
+
+// memoryPersistedQueryStore keeps a fixed number of documents in memory, evicting the least recently used one
+type memoryPersistedQueryStore struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type memoryPersistedQuery struct {
+	hash  string
+	query string
+}
+
+func newMemoryPersistedQueryStore(size int) *memoryPersistedQueryStore {
+	return &memoryPersistedQueryStore{
+		size:    size,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+func (s *memoryPersistedQueryStore) Get(ctx context.Context, hash string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	element, exists := s.entries[hash]
+	if !exists {
+		return "", false, nil
+	}
+	s.order.MoveToFront(element)
+	return element.Value.(memoryPersistedQuery).query, true, nil
+}
+
+func (s *memoryPersistedQueryStore) Put(ctx context.Context, hash string, query string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if element, exists := s.entries[hash]; exists {
+		s.order.MoveToFront(element)
+		return nil
+	}
+	s.entries[hash] = s.order.PushFront(memoryPersistedQuery{hash, query})
+	if s.order.Len() > s.size {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(memoryPersistedQuery).hash)
+	}
+	return nil
+}
+
+// postgresPersistedQueryStore keeps documents in the persisted_query table, shared by all instances
+type postgresPersistedQueryStore struct {
+	db *sqlx.DB
+}
+
+func (s *postgresPersistedQueryStore) Get(ctx context.Context, hash string) (string, bool, error) {
+	return getPersistedQuery(ctx, s.db, hash)
+}
+
+func (s *postgresPersistedQueryStore) Put(ctx context.Context, hash string, query string) error {
+	return putPersistedQuery(ctx, s.db, hash, query)
+}
+
+// persistedQueryExtension is the persistedQuery entry of a request's extensions
+type persistedQueryExtension struct {
+	Version    int    `json:"version"`
+	SHA256Hash string `json:"sha256Hash"`
+}
+
+type persistedQueryExtensions struct {
+	PersistedQuery *persistedQueryExtension `json:"persistedQuery"`
+}
+
+func queryHash(query string) string {
+	hash := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(hash[:])
+}
+
+// persistedQueries resolves automatic persisted queries: clients send the hash of a document and only send
+// the document itself (which is then registered) when the server doesn't know it yet.
+// In strict mode only the documents of the manifest may run.
+type persistedQueries struct {
+	store    PersistedQueryStore
+	manifest map[string]string
+	strict   bool
+}
+
+func newPersistedQueries(ctx context.Context, config PersistedQueriesConfig, db *sqlx.DB) (*persistedQueries, error) {
+	queries := &persistedQueries{strict: config.Strict, manifest: map[string]string{}}
+	if config.ManifestFile != "" {
+		manifest, err := loadPersistedQueryManifest(config.ManifestFile)
+		if err != nil {
+			return nil, err
+		}
+		queries.manifest = manifest
+	}
+
+	switch config.Store {
+	case "memory":
+		queries.store = newMemoryPersistedQueryStore(config.CacheSize)
+	case "postgres":
+		if err := ensurePersistedQueryTable(ctx, db); err != nil {
+			return nil, fmt.Errorf("error creating persisted query store: %v", err)
+		}
+		queries.store = &postgresPersistedQueryStore{db}
+	default:
+		return nil, fmt.Errorf("unknown persisted query store \"%s\"", config.Store)
+	}
+	return queries, nil
+}
+
+// loadPersistedQueryManifest reads a manifest of documents by their hash, either an apollo persisted query
+// manifest ({"operations": [{"id": hash, "body": document}]}) or a plain {hash: document} object
+func loadPersistedQueryManifest(path string) (map[string]string, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var apolloManifest struct {
+		Operations []struct {
+			ID   string `json:"id"`
+			Body string `json:"body"`
+		} `json:"operations"`
+	}
+	if err := json.Unmarshal(raw, &apolloManifest); err != nil {
+		return nil, fmt.Errorf("invalid persisted query manifest %s: %v", path, err)
+	}
+	manifest := map[string]string{}
+	if apolloManifest.Operations != nil {
+		for _, operation := range apolloManifest.Operations {
+			manifest[operation.ID] = operation.Body
+		}
+	} else if err := json.Unmarshal(raw, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid persisted query manifest %s: %v", path, err)
+	}
+
+	normalized := make(map[string]string, len(manifest))
+	for hash, query := range manifest {
+		hash = strings.ToLower(hash)
+		if queryHash(query) != hash {
+			return nil, fmt.Errorf("persisted query %s in %s doesn't match its hash", hash, path)
+		}
+		normalized[hash] = query
+	}
+	return normalized, nil
+}
+
+// resolve returns the document to execute for a request's query and persistedQuery extension (nil if it has none)
+func (queries *persistedQueries) resolve(ctx context.Context, query string, extension *persistedQueryExtension) (string, error) {
+	notAllowed := persistedQueryError{errCodePersistedQueryNotAllowed, "only queries from the persisted query manifest are allowed"}
+
+	if extension == nil {
+		if queries.strict && query != "" {
+			if _, registered := queries.manifest[queryHash(query)]; !registered {
+				return "", notAllowed
+			}
+		}
+		return query, nil
+	}
+
+	if extension.Version != 1 {
+		return "", persistedQueryError{errCodePersistedQueryNotSupported, "PersistedQueryNotSupported"}
+	}
+	hash := strings.ToLower(extension.SHA256Hash)
+
+	if query == "" {
+		if registered, exists := queries.manifest[hash]; exists {
+			return registered, nil
+		}
+		if queries.strict {
+			return "", notAllowed
+		}
+		registered, exists, err := queries.store.Get(ctx, hash)
+		if err != nil {
+			log.Printf("error reading persisted query %s: %v", hash, err)
+		}
+		if !exists {
+			return "", persistedQueryError{errCodePersistedQueryNotFound, "PersistedQueryNotFound"}
+		}
+		return registered, nil
+	}
+
+	if queryHash(query) != hash {
+		return "", persistedQueryError{errCodePersistedQueryHashMismatch, "provided sha256Hash does not match the query"}
+	}
+	if _, registered := queries.manifest[hash]; registered {
+		return query, nil
+	}
+	if queries.strict {
+		return "", notAllowed
+	}
+	if err := queries.store.Put(ctx, hash, query); err != nil {
+		log.Printf("error registering persisted query %s: %v", hash, err)
+	}
+	return query, nil
+}
+
+// persistedQueryHandler replaces the hash of persisted queries in graphql requests with the full document
+// (in the url for GET requests, in the json body otherwise) before passing them to h
+func persistedQueryHandler(queries *persistedQueries, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := requestBody(r)
+
+		// Like the graphql handler, prefer a query in the url, then the body. Extensions in the url only go
+		// with a query in the url or a hash-only request without a body, otherwise the body's query would be
+		// executed without being checked against the url's extensions
+		values := r.URL.Query()
+		urlExtensions := values.Get("extensions") != ""
+		if urlExtensions && values.Get("query") == "" && len(body) > 0 {
+			writeErrorResult(w, http.StatusBadRequest, apiError{errCodeInvalidQuery, "", "extensions in the url can't be combined with a body"}, nil)
+			return
+		}
+		inURL := values.Get("query") != "" || urlExtensions
+		contentType := strings.Split(r.Header.Get("Content-Type"), ";")[0]
+		inJSONBody := !inURL && r.Method == http.MethodPost && contentType != handler.ContentTypeGraphQL && contentType != handler.ContentTypeFormURLEncoded
+
+		var query string
+		var extensions persistedQueryExtensions
+		var fields map[string]json.RawMessage
+		switch {
+		case inURL:
+			query = values.Get("query")
+			json.Unmarshal([]byte(values.Get("extensions")), &extensions)
+		case inJSONBody:
+			json.Unmarshal(body, &fields)
+			json.Unmarshal(fields["query"], &query)
+			json.Unmarshal(fields["extensions"], &extensions)
+		default:
+			query = handler.NewRequestOptions(r).Query
+			requestBody(r)
+		}
+
+		resolved, err := queries.resolve(r.Context(), query, extensions.PersistedQuery)
+		if err != nil {
+			writeErrorResult(w, http.StatusOK, err.(persistedQueryError), nil)
+			return
+		}
+
+		if resolved != query {
+			if inURL {
+				values.Set("query", resolved)
+				r.URL.RawQuery = values.Encode()
+			} else if inJSONBody {
+				fields["query"], _ = json.Marshal(resolved)
+				body, _ = json.Marshal(fields)
+				r = withRequestBody(r, body)
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
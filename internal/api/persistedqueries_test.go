@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/graphql-go/handler"
+)
+
+func TestPersistedQueryHandlerStrict(t *testing.T) {
+	registered := `{ buildings { name } }`
+	unregistered := `{ rooms { name } }`
+	queries := &persistedQueries{
+		store:    newMemoryPersistedQueryStore(10),
+		manifest: map[string]string{queryHash(registered): registered},
+		strict:   true,
+	}
+	hashExtensions := `{"persistedQuery":{"version":1,"sha256Hash":"` + queryHash(registered) + `"}}`
+
+	tests := []struct {
+		name     string
+		method   string
+		url      string
+		body     string
+		executed string // the document the graphql handler would execute, empty if the request is rejected
+	}{
+		{"registered query in the body", http.MethodPost, "/graphql", `{"query":"{ buildings { name } }"}`, registered},
+		{"unregistered query in the body", http.MethodPost, "/graphql", `{"query":"{ rooms { name } }"}`, ""},
+		{"unregistered query in the url", http.MethodGet, "/graphql?query=" + url.QueryEscape(unregistered), "", ""},
+		{"hash of a registered query in the url", http.MethodGet, "/graphql?extensions=" + url.QueryEscape(hashExtensions), "", registered},
+		{"hash of a registered query in the body", http.MethodPost, "/graphql", `{"extensions":` + hashExtensions + `}`, registered},
+		{"registered query in the url with a body", http.MethodPost, "/graphql?query=" + url.QueryEscape(registered), `{"query":"{ rooms { name } }"}`, registered},
+		{"empty url extensions with an unregistered query in the body", http.MethodPost, "/graphql?extensions=%7B%7D", `{"query":"{ rooms { name } }"}`, ""},
+		{"url hash with an unregistered query in the body", http.MethodPost, "/graphql?extensions=" + url.QueryEscape(hashExtensions), `{"query":"{ rooms { name } }"}`, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			executed := ""
+			h := bodyHandler(1<<20, persistedQueryHandler(queries, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				executed = handler.NewRequestOptions(r).Query
+			})))
+			r := httptest.NewRequest(test.method, test.url, strings.NewReader(test.body))
+			r.Header.Set("Content-Type", "application/json")
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if executed != test.executed {
+				t.Errorf("executed %q, want %q", executed, test.executed)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+package api
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Navigation edge kinds
+const (
+	navEdgeWalk     = "walk"
+	navEdgeStairs   = "stairs"
+	navEdgeElevator = "elevator"
+)
+
+// Extra cost (in meters of walking) of changing a level, on top of the edge's length
+const (
+	stairsCostPerLevel = 10
+	elevatorCost       = 30
+)
+
+// routeEndpoint is a point on a level to route from or to, optionally inside a specific room
+type routeEndpoint struct {
+	coordinates  Coordinates
+	level        int
+	levelPostfix *string
+	room         *int
+}
+
+type routeOptions struct {
+	avoidStairs bool
+}
+
+// LevelChange is a change of level at the end of a route leg
+type LevelChange struct {
+	Via            string
+	ToLevel        int
+	ToLevelPostfix *string
+}
+
+// RouteLeg is the part of a route on a single level
+type RouteLeg struct {
+	Level        int
+	LevelPostfix *string
+	Geometry     string
+	Length       float64
+	LevelChange  *LevelChange
+}
+
+// Route is an ordered list of legs from one endpoint to another
+type Route struct {
+	Length float64
+	Legs   []RouteLeg
+}
+
+// Router finds routes through the indoor navigation graph
+type Router interface {
+	Route(ctx context.Context, from routeEndpoint, to routeEndpoint, options routeOptions) (Route, error)
+}
+
+// navArc is a directed edge in the navigation graph
+type navArc struct {
+	to     int
+	kind   string
+	length float64
+}
+
+type navGraph struct {
+	nodes     map[int]NavNode
+	adjacency map[int][]navArc
+}
+
+func newNavGraph(nodes []NavNode, edges []NavEdge) *navGraph {
+	graph := &navGraph{
+		nodes:     make(map[int]NavNode, len(nodes)),
+		adjacency: make(map[int][]navArc, len(nodes)),
+	}
+	for _, node := range nodes {
+		graph.nodes[node.ID] = node
+	}
+	for _, edge := range edges {
+		from, fromExists := graph.nodes[edge.FromNode]
+		to, toExists := graph.nodes[edge.ToNode]
+		if !fromExists || !toExists {
+			continue
+		}
+		length := haversine(from.coordinates(), to.coordinates())
+		if edge.Length != nil {
+			length = *edge.Length
+		}
+		graph.adjacency[edge.FromNode] = append(graph.adjacency[edge.FromNode], navArc{edge.ToNode, edge.Kind, length})
+		if !edge.Oneway {
+			graph.adjacency[edge.ToNode] = append(graph.adjacency[edge.ToNode], navArc{edge.FromNode, edge.Kind, length})
+		}
+	}
+	return graph
+}
+
+const earthRadius = 6371008.8
+
+// haversine returns the great-circle distance between a and b in meters
+func haversine(a Coordinates, b Coordinates) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(b.Lat - a.Lat)
+	dLon := toRad(b.Lon - a.Lon)
+	h := math.Pow(math.Sin(dLat/2), 2) + math.Cos(toRad(a.Lat))*math.Cos(toRad(b.Lat))*math.Pow(math.Sin(dLon/2), 2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+func sameLevel(level int, levelPostfix *string, otherLevel int, otherLevelPostfix *string) bool {
+	if level != otherLevel {
+		return false
+	}
+	if levelPostfix == nil || otherLevelPostfix == nil {
+		return levelPostfix == otherLevelPostfix
+	}
+	return *levelPostfix == *otherLevelPostfix
+}
+
+// snap returns the node closest to the endpoint on its level, preferring nodes in the endpoint's room
+func (graph *navGraph) snap(endpoint routeEndpoint) (int, error) {
+	bestID := -1
+	bestDistance := math.Inf(1)
+	bestInRoom := false
+	for id, node := range graph.nodes {
+		if !sameLevel(node.Level, node.LevelPostfix, endpoint.level, endpoint.levelPostfix) {
+			continue
+		}
+		inRoom := endpoint.room != nil && node.Room != nil && *node.Room == *endpoint.room
+		distance := haversine(node.coordinates(), endpoint.coordinates)
+		if (inRoom && !bestInRoom) || (inRoom == bestInRoom && distance < bestDistance) {
+			bestID, bestDistance, bestInRoom = id, distance, inRoom
+		}
+	}
+	if bestID == -1 {
+		return 0, notFoundError("", "Found no navigation nodes on level %d", endpoint.level)
+	}
+	return bestID, nil
+}
+
+func (arc navArc) cost(graph *navGraph, from int, options routeOptions) (float64, bool) {
+	switch arc.kind {
+	case navEdgeStairs:
+		if options.avoidStairs {
+			return 0, false
+		}
+		levels := math.Abs(float64(graph.nodes[from].Level - graph.nodes[arc.to].Level))
+		return arc.length + stairsCostPerLevel*math.Max(levels, 1), true
+	case navEdgeElevator:
+		return arc.length + elevatorCost, true
+	default:
+		return arc.length, true
+	}
+}
+
+// aStarItem is a node in the A* open set
+type aStarItem struct {
+	node     int
+	priority float64
+}
+
+type aStarQueue []aStarItem
+
+func (q aStarQueue) Len() int            { return len(q) }
+func (q aStarQueue) Less(i, j int) bool  { return q[i].priority < q[j].priority }
+func (q aStarQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *aStarQueue) Push(x interface{}) { *q = append(*q, x.(aStarItem)) }
+func (q *aStarQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// aStar returns the cheapest path of nodes from start to goal, using the great-circle distance as heuristic
+func (graph *navGraph) aStar(start int, goal int, options routeOptions) ([]int, error) {
+	goalCoordinates := graph.nodes[goal].coordinates()
+	heuristic := func(node int) float64 {
+		return haversine(graph.nodes[node].coordinates(), goalCoordinates)
+	}
+
+	costs := map[int]float64{start: 0}
+	previous := map[int]int{}
+	open := &aStarQueue{{start, heuristic(start)}}
+	closed := map[int]bool{}
+
+	for open.Len() > 0 {
+		current := heap.Pop(open).(aStarItem).node
+		if current == goal {
+			path := []int{goal}
+			for path[0] != start {
+				path = append([]int{previous[path[0]]}, path...)
+			}
+			return path, nil
+		}
+		if closed[current] {
+			continue
+		}
+		closed[current] = true
+
+		for _, arc := range graph.adjacency[current] {
+			arcCost, allowed := arc.cost(graph, current, options)
+			if !allowed || closed[arc.to] {
+				continue
+			}
+			cost := costs[current] + arcCost
+			if known, exists := costs[arc.to]; !exists || cost < known {
+				costs[arc.to] = cost
+				previous[arc.to] = current
+				heap.Push(open, aStarItem{arc.to, cost + heuristic(arc.to)})
+			}
+		}
+	}
+
+	return nil, notFoundError("", "Found no route between the given endpoints")
+}
+
+func (graph *navGraph) arc(from int, to int) navArc {
+	for _, arc := range graph.adjacency[from] {
+		if arc.to == to {
+			return arc
+		}
+	}
+	return navArc{to, navEdgeWalk, haversine(graph.nodes[from].coordinates(), graph.nodes[to].coordinates())}
+}
+
+func legGeometry(points []Coordinates) string {
+	coords := make([]string, len(points))
+	for i, point := range points {
+		coords[i] = strconv.FormatFloat(point.Lon, 'f', -1, 64) + " " + strconv.FormatFloat(point.Lat, 'f', -1, 64)
+	}
+	if len(points) == 1 {
+		return fmt.Sprintf("POINT(%s)", coords[0])
+	}
+	return fmt.Sprintf("LINESTRING(%s)", strings.Join(coords, ", "))
+}
+
+// route splits a path of nodes into legs per level
+func (graph *navGraph) route(path []int) Route {
+	var route Route
+	first := graph.nodes[path[0]]
+	leg := RouteLeg{Level: first.Level, LevelPostfix: first.LevelPostfix}
+	points := []Coordinates{first.coordinates()}
+
+	for i := 1; i < len(path); i++ {
+		from := graph.nodes[path[i-1]]
+		to := graph.nodes[path[i]]
+		arc := graph.arc(from.ID, to.ID)
+		route.Length += arc.length
+
+		if sameLevel(from.Level, from.LevelPostfix, to.Level, to.LevelPostfix) {
+			leg.Length += arc.length
+			points = append(points, to.coordinates())
+			continue
+		}
+
+		leg.Geometry = legGeometry(points)
+		leg.LevelChange = &LevelChange{strings.ToUpper(arc.kind), to.Level, to.LevelPostfix}
+		route.Legs = append(route.Legs, leg)
+		leg = RouteLeg{Level: to.Level, LevelPostfix: to.LevelPostfix}
+		points = []Coordinates{to.coordinates()}
+	}
+
+	leg.Geometry = legGeometry(points)
+	route.Legs = append(route.Legs, leg)
+	return route
+}
+
+// aStarRouter routes with A* over an in-memory copy of the navigation graph that is reloaded after ttl
+type aStarRouter struct {
+	db  *sqlx.DB
+	ttl time.Duration
+
+	mu       sync.Mutex
+	graph    *navGraph
+	loadedAt time.Time
+}
+
+func newAStarRouter(db *sqlx.DB, ttl time.Duration) *aStarRouter {
+	return &aStarRouter{db: db, ttl: ttl}
+}
+
+func (router *aStarRouter) loadGraph(ctx context.Context) (*navGraph, error) {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	if router.graph != nil && time.Since(router.loadedAt) < router.ttl {
+		return router.graph, nil
+	}
+
+	nodes, edges, err := getNavGraph(ctx, router.db)
+	if err != nil {
+		return nil, err
+	}
+	router.graph = newNavGraph(nodes, edges)
+	router.loadedAt = time.Now()
+	return router.graph, nil
+}
+
+func (router *aStarRouter) Route(ctx context.Context, from routeEndpoint, to routeEndpoint, options routeOptions) (Route, error) {
+	graph, err := router.loadGraph(ctx)
+	if err != nil {
+		return Route{}, err
+	}
+
+	start, err := graph.snap(from)
+	if err != nil {
+		return Route{}, notFoundError("from", "cannot route from <from>: %v", err)
+	}
+	goal, err := graph.snap(to)
+	if err != nil {
+		return Route{}, notFoundError("to", "cannot route to <to>: %v", err)
+	}
+
+	path, err := graph.aStar(start, goal, options)
+	if err != nil {
+		return Route{}, err
+	}
+	return graph.route(path), nil
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRoute(t *testing.T) {
+	elevatorLength := 0.0
+	stairsLength := 4.0
+	nodes := []NavNode{
+		{ID: 1, Lon: 0, Lat: 0, Level: 0},
+		{ID: 2, Lon: 0.0001, Lat: 0, Level: 0},
+		{ID: 3, Lon: 0, Lat: 0.0003, Level: 0},
+		{ID: 4, Lon: 0.0001, Lat: 0, Level: 1},
+		{ID: 5, Lon: 0, Lat: 0.0003, Level: 1},
+		{ID: 6, Lon: 0.0002, Lat: 0, Level: 1},
+		// Only reachable from 6, over a one-way edge
+		{ID: 7, Lon: 0.0003, Lat: 0, Level: 1},
+		// Not connected at all
+		{ID: 8, Lon: 0.0005, Lat: 0.0005, Level: 1},
+	}
+	edges := []NavEdge{
+		{FromNode: 1, ToNode: 2, Kind: navEdgeWalk},
+		{FromNode: 2, ToNode: 4, Kind: navEdgeStairs, Length: &stairsLength},
+		{FromNode: 4, ToNode: 6, Kind: navEdgeWalk},
+		{FromNode: 1, ToNode: 3, Kind: navEdgeWalk},
+		{FromNode: 3, ToNode: 5, Kind: navEdgeElevator, Length: &elevatorLength},
+		{FromNode: 5, ToNode: 6, Kind: navEdgeWalk},
+		{FromNode: 6, ToNode: 7, Kind: navEdgeWalk, Oneway: true},
+	}
+	graph := newNavGraph(nodes, edges)
+	router := &aStarRouter{graph: graph, loadedAt: time.Now(), ttl: time.Hour}
+
+	at := func(id int) routeEndpoint {
+		node := graph.nodes[id]
+		return routeEndpoint{coordinates: node.coordinates(), level: node.Level}
+	}
+	distance := func(from int, to int) float64 {
+		return haversine(graph.nodes[from].coordinates(), graph.nodes[to].coordinates())
+	}
+
+	type leg struct {
+		level    int
+		geometry string
+		via      string
+	}
+	tests := []struct {
+		name    string
+		from    routeEndpoint
+		to      routeEndpoint
+		options routeOptions
+		legs    []leg
+		length  float64
+		err     bool
+	}{
+		{
+			name: "two levels over the stairs",
+			from: at(1),
+			to:   at(6),
+			legs: []leg{
+				{0, "LINESTRING(0 0, 0.0001 0)", "STAIRS"},
+				{1, "LINESTRING(0.0001 0, 0.0002 0)", ""},
+			},
+			length: distance(1, 2) + stairsLength + distance(4, 6),
+		},
+		{
+			name:    "avoidStairs takes the elevator",
+			from:    at(1),
+			to:      at(6),
+			options: routeOptions{avoidStairs: true},
+			legs: []leg{
+				{0, "LINESTRING(0 0, 0 0.0003)", "ELEVATOR"},
+				{1, "LINESTRING(0 0.0003, 0.0002 0)", ""},
+			},
+			length: distance(1, 3) + elevatorLength + distance(5, 6),
+		},
+		{
+			name: "single level",
+			from: at(4),
+			to:   at(7),
+			legs: []leg{
+				{1, "LINESTRING(0.0001 0, 0.0002 0, 0.0003 0)", ""},
+			},
+			length: distance(4, 6) + distance(6, 7),
+		},
+		{
+			name: "start is the goal",
+			from: at(1),
+			to:   at(1),
+			legs: []leg{
+				{0, "POINT(0 0)", ""},
+			},
+		},
+		{
+			name: "unreachable target",
+			from: at(1),
+			to:   at(8),
+			err:  true,
+		},
+		{
+			name: "against a one-way edge",
+			from: at(7),
+			to:   at(6),
+			err:  true,
+		},
+		{
+			name: "level without nodes",
+			from: at(1),
+			to:   routeEndpoint{level: 2},
+			err:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			route, err := router.Route(context.Background(), test.from, test.to, test.options)
+			if test.err {
+				if err == nil {
+					t.Fatalf("found route %+v, want an error", route)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(route.Length-test.length) > 1e-6 {
+				t.Errorf("length = %f, want %f", route.Length, test.length)
+			}
+			if len(route.Legs) != len(test.legs) {
+				t.Fatalf("legs = %+v, want %d legs", route.Legs, len(test.legs))
+			}
+			for i, want := range test.legs {
+				got := route.Legs[i]
+				via := ""
+				if got.LevelChange != nil {
+					via = got.LevelChange.Via
+				}
+				if got.Level != want.level || got.Geometry != want.geometry || via != want.via {
+					t.Errorf("leg %d = {%d %s %s}, want %+v", i, got.Level, got.Geometry, via, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNavArcCost(t *testing.T) {
+	nodes := []NavNode{{ID: 1, Level: 0}, {ID: 2, Level: 3}}
+	graph := newNavGraph(nodes, nil)
+
+	tests := []struct {
+		name    string
+		arc     navArc
+		options routeOptions
+		cost    float64
+		allowed bool
+	}{
+		{"walk", navArc{2, navEdgeWalk, 5}, routeOptions{}, 5, true},
+		{"stairs per level", navArc{2, navEdgeStairs, 5}, routeOptions{}, 5 + 3*stairsCostPerLevel, true},
+		{"stairs avoided", navArc{2, navEdgeStairs, 5}, routeOptions{avoidStairs: true}, 0, false},
+		{"elevator", navArc{2, navEdgeElevator, 5}, routeOptions{avoidStairs: true}, 5 + elevatorCost, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cost, allowed := test.arc.cost(graph, 1, test.options)
+			if cost != test.cost || allowed != test.allowed {
+				t.Errorf("cost = %f, %t, want %f, %t", cost, allowed, test.cost, test.allowed)
+			}
+		})
+	}
+}
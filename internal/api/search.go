@@ -0,0 +1,192 @@
+package api
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+const defaultSearchLimit = 20
+const maxSearchLimit = 100
+
+// searchNearScale is the distance (in meters) at which a result's score is halved when searching near a point
+const searchNearScale = 500
+
+type searchFilterConfig struct {
+	query string
+	near  *Coordinates
+	limit int
+}
+
+func parseSearchFilterArgs(args map[string]interface{}) (searchFilterConfig, error) {
+	var config searchFilterConfig
+
+	config.query = strings.TrimSpace(args["query"].(string))
+	if config.query == "" {
+		return config, invalidArgumentError("query", "<query> cannot be empty")
+	}
+
+	if nearArg := args["near"]; nearArg != nil {
+		near := nearArg.(map[string]interface{})
+		config.near = &Coordinates{near["lon"].(float64), near["lat"].(float64)}
+	}
+
+	config.limit = defaultSearchLimit
+	if limitArg := args["limit"]; limitArg != nil {
+		config.limit = limitArg.(int)
+	}
+	if config.limit < 1 || config.limit > maxSearchLimit {
+		return config, invalidArgumentError("limit", "<limit> (%d) must be between 1 and %d", config.limit, maxSearchLimit)
+	}
+
+	return config, nil
+}
+
+// searchRoomRow is a room matching a search, with the fields it is matched on
+type searchRoomRow struct {
+	Score        float64
+	BuildingName *string `db:"building_name"`
+	Room
+}
+
+// searchBuildingRow is a building matching a search, with the fields it is matched on
+type searchBuildingRow struct {
+	Score           float64
+	AddressFree     *string `db:"address_free"`
+	AddressLocality *string `db:"address_locality"`
+	AddressPostcode *string `db:"address_postcode"`
+	Building
+}
+
+// SearchSpan is a highlighted part of a field, in unicode code points
+type SearchSpan struct {
+	Start int
+	End   int
+}
+
+// SearchMatch is a field that matched the search query
+type SearchMatch struct {
+	Field      string
+	Text       string
+	Highlights []SearchSpan
+}
+
+// SearchResult is a Room or Building that matched the search query
+type SearchResult struct {
+	Item    interface{}
+	Score   float64
+	Matches []SearchMatch
+}
+
+// searchField is a named field of a search result that can be highlighted
+type searchField struct {
+	name string
+	text *string
+}
+
+// foldRune lowercases r and strips its accents, a single rune can fold to multiple runes (or none)
+func foldRune(r rune) string {
+	folder := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(folder, string(r))
+	if err != nil {
+		folded = string(r)
+	}
+	return strings.ToLower(folded)
+}
+
+// highlight returns the spans of text (in code points) that contain any of the query's words,
+// ignoring case and accents
+func highlight(text string, query string) []SearchSpan {
+	var folded strings.Builder
+	// origin maps every byte of folded to the index of the rune of text it came from
+	var origin []int
+	runeIndex := 0
+	for _, r := range text {
+		f := foldRune(r)
+		folded.WriteString(f)
+		for i := 0; i < len(f); i++ {
+			origin = append(origin, runeIndex)
+		}
+		runeIndex++
+	}
+	foldedText := folded.String()
+
+	var spans []SearchSpan
+	for _, word := range strings.Fields(query) {
+		var foldedWord strings.Builder
+		for _, r := range word {
+			foldedWord.WriteString(foldRune(r))
+		}
+		needle := foldedWord.String()
+		if needle == "" {
+			continue
+		}
+		for offset := 0; offset < len(foldedText); {
+			i := strings.Index(foldedText[offset:], needle)
+			if i < 0 {
+				break
+			}
+			start := offset + i
+			end := start + len(needle)
+			spans = append(spans, SearchSpan{origin[start], origin[end-1] + 1})
+			offset = end
+		}
+	}
+
+	return mergeSpans(spans)
+}
+
+func mergeSpans(spans []SearchSpan) []SearchSpan {
+	if len(spans) == 0 {
+		return nil
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+	merged := []SearchSpan{spans[0]}
+	for _, span := range spans[1:] {
+		last := &merged[len(merged)-1]
+		if span.Start <= last.End {
+			if span.End > last.End {
+				last.End = span.End
+			}
+			continue
+		}
+		merged = append(merged, span)
+	}
+	return merged
+}
+
+func searchMatches(fields []searchField, query string) []SearchMatch {
+	var matches []SearchMatch
+	for _, field := range fields {
+		if field.text == nil {
+			continue
+		}
+		if spans := highlight(*field.text, query); len(spans) > 0 {
+			matches = append(matches, SearchMatch{field.name, *field.text, spans})
+		}
+	}
+	return matches
+}
+
+// mergeSearchResults ranks the room and building results together
+func mergeSearchResults(filterConfig searchFilterConfig, rooms []searchRoomRow, buildings []searchBuildingRow) []SearchResult {
+	var results []SearchResult
+	for _, row := range rooms {
+		fields := []searchField{{"name", row.Name}, {"ref", row.Ref}, {"building.name", row.BuildingName}}
+		results = append(results, SearchResult{row.Room, row.Score, searchMatches(fields, filterConfig.query)})
+	}
+	for _, row := range buildings {
+		fields := []searchField{{"name", row.Name}, {"address.free", row.AddressFree}, {"address.locality", row.AddressLocality}, {"address.postcode", row.AddressPostcode}}
+		results = append(results, SearchResult{row.Building, row.Score, searchMatches(fields, filterConfig.query)})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if len(results) > filterConfig.limit {
+		results = results[:filterConfig.limit]
+	}
+	return results
+}
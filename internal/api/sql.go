@@ -1,29 +1,86 @@
 package api
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"strings"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
-func getByUID(db *sqlx.DB, tableConfig TableConfig, uid string, dest interface{}) error {
-	err := db.Get(dest, fmt.Sprintf("SELECT %s FROM %s WHERE uid=$1;", tableConfig.Columns, tableConfig.TableName), uid)
-	if err == sql.ErrNoRows {
-		return fmt.Errorf("Found no %s with <uid> (%s)", tableConfig.elementName(), uid)
+// srid is the spatial reference system of every stored geometry and coordinate: WGS 84 longitude/latitude.
+// Distances and areas are computed on the geography (spheroid), in meters and square meters.
+const srid = 4326
+
+// qPoint returns the sql expression of the point with the lon and lat placeholders
+func qPoint(lon string, lat string) string {
+	return fmt.Sprintf("ST_SetSRID(ST_MakePoint(%s, %s), %d)", lon, lat, srid)
+}
+
+// qDistance returns the sql expression of the distance in meters between a geometry expression and the geometry column
+func qDistance(from string) string {
+	return fmt.Sprintf("ST_Distance((%s)::geography, geometry::geography)", from)
+}
+
+// qWithin returns the sql condition that the geometry column is within the distance placeholder (in meters) of
+// a geometry expression. Unlike comparing qDistance it can use a GiST index on geometry::geography.
+func qWithin(from string, distance string) string {
+	return fmt.Sprintf("ST_DWithin(geometry::geography, (%s)::geography, %s)", from, distance)
+}
+
+// qEnvelope returns the sql expression of the lon/lat rectangle with the placeholders as bounds
+func qEnvelope(minLon string, minLat string, maxLon string, maxLat string) string {
+	return fmt.Sprintf("ST_MakeEnvelope(%s, %s, %s, %s, %d)", minLon, minLat, maxLon, maxLat, srid)
+}
+
+// isNoRows reports whether err means no row matched, which includes uids that aren't valid uuids
+func isNoRows(err error) bool {
+	if pqErr, isPQErr := err.(*pq.Error); isPQErr && pqErr.Code == "22P02" {
+		return true
+	}
+	return err == sql.ErrNoRows
+}
+
+// getByUID gets the element with the uid passed as argument
+func getByUID(ctx context.Context, db sqlx.QueryerContext, tableConfig TableConfig, argument string, uid string, dest interface{}) error {
+	observed := observeQuery("getByUID", tableConfig)
+	err := sqlx.GetContext(ctx, db, dest, fmt.Sprintf("SELECT %s FROM %s WHERE uid=$1;", tableConfig.Columns, tableConfig.TableName), uid)
+	observed(err)
+	if isNoRows(err) {
+		return notFoundError(argument, "Found no %s with <%s> (%s)", tableConfig.elementName(), argument, uid)
 	}
 	return err
 }
 
-func getByID(db *sqlx.DB, tableConfig TableConfig, id int, dest interface{}) error {
-	err := db.Get(dest, fmt.Sprintf("SELECT %s FROM %s WHERE id=$1;", tableConfig.Columns, tableConfig.TableName), id)
+func getByID(ctx context.Context, db *sqlx.DB, tableConfig TableConfig, id int, dest interface{}) error {
+	observed := observeQuery("getByID", tableConfig)
+	err := db.GetContext(ctx, dest, fmt.Sprintf("SELECT %s FROM %s WHERE id=$1;", tableConfig.Columns, tableConfig.TableName), id)
+	observed(err)
 	if err == sql.ErrNoRows {
-		return fmt.Errorf("Found no %s with the a specific internal id, this is a data consistency error that should never occur", tableConfig.elementName())
+		return errNoElementWithID(tableConfig)
 	}
 	return err
 }
 
-func getFilteredRoomsByBuildingID(db *sqlx.DB, filterConfig buildingRoomFilterConfig, id int) ([]Room, error) {
+func errNoElementWithID(tableConfig TableConfig) error {
+	return dataInconsistencyError("Found no %s with the a specific internal id, this is a data consistency error that should never occur", tableConfig.elementName())
+}
+
+func getByIDs(ctx context.Context, db *sqlx.DB, tableConfig TableConfig, ids []int, dest interface{}) error {
+	int64IDs := make(pq.Int64Array, len(ids))
+	for i, id := range ids {
+		int64IDs[i] = int64(id)
+	}
+	observed := observeQuery("getByIDs", tableConfig)
+	err := db.SelectContext(ctx, dest, fmt.Sprintf("SELECT %s FROM %s WHERE id = ANY($1);", tableConfig.Columns, tableConfig.TableName), int64IDs)
+	observed(err)
+	return err
+}
+
+// buildingRoomsQuery returns the query (without order) for the rooms of a building matching filterConfig and its args
+func buildingRoomsQuery(filterConfig buildingRoomFilterConfig, id int) (string, []interface{}) {
 	args := []interface{}{id}
 
 	qOptionalLevelFilter := ""
@@ -41,6 +98,9 @@ func getFilteredRoomsByBuildingID(db *sqlx.DB, filterConfig buildingRoomFilterCo
 		levelPostfixFilterValue = []interface{}{filterConfig.levelPostfix.filter}
 	}
 	args = append(args, levelPostfixFilterValue...)
+	if filterConfig.withoutLevelPostfix {
+		qOptionalLevelPostfixFilter = "AND level_postfix IS NULL"
+	}
 
 	qOptionalNameFilter := ""
 	var nameFilterValue []interface{}
@@ -50,18 +110,91 @@ func getFilteredRoomsByBuildingID(db *sqlx.DB, filterConfig buildingRoomFilterCo
 	}
 	args = append(args, nameFilterValue...)
 
-	var rooms []Room
 	q := fmt.Sprintf(`
-		SELECT %s FROM %s WHERE building=$1 %s %s %s;
+		SELECT %s FROM %s WHERE building=$1 %s %s %s
 	`, roomConfig.Columns, roomConfig.TableName, qOptionalLevelFilter, qOptionalLevelPostfixFilter, qOptionalNameFilter)
-	err := db.Select(&rooms, q, args...)
+	return q, args
+}
+
+// getFilteredRoomsByBuildingID returns the first maxPageSize (by id) rooms of the building matching filterConfig
+func getFilteredRoomsByBuildingID(ctx context.Context, db *sqlx.DB, filterConfig buildingRoomFilterConfig, id int) ([]Room, error) {
+	q, args := buildingRoomsQuery(filterConfig, id)
+	var rooms []Room
+	observed := observeQuery("getFilteredRoomsByBuildingID", roomConfig)
+	err := db.SelectContext(ctx, &rooms, fmt.Sprintf("%s ORDER BY id LIMIT %d;", q, maxPageSize), args...)
+	observed(err)
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("Found no rooms for this building")
+		return nil, notFoundError("", "Found no rooms for this building")
 	}
 	return rooms, err
 }
 
-func getIntersectingRooms(db *sqlx.DB, filterConfig roomIntersectFilterConfig, id int) ([]Room, error) {
+func getFilteredRoomsByBuildingIDPage(ctx context.Context, db *sqlx.DB, filterConfig buildingRoomFilterConfig, id int, page pageConfig) ([]Room, error) {
+	q, args := buildingRoomsQuery(filterConfig, id)
+	qKeyset, args := page.keyset("", args)
+	var rooms []Room
+	observed := observeQuery("getFilteredRoomsByBuildingIDPage", roomConfig)
+	err := db.SelectContext(ctx, &rooms, fmt.Sprintf("%s %s;", q, qKeyset), args...)
+	observed(err)
+	return rooms, err
+}
+
+func countFilteredRoomsByBuildingID(ctx context.Context, db *sqlx.DB, filterConfig buildingRoomFilterConfig, id int) (int, error) {
+	q, args := buildingRoomsQuery(filterConfig, id)
+	var count int
+	observed := observeQuery("countFilteredRoomsByBuildingID", roomConfig)
+	err := db.GetContext(ctx, &count, fmt.Sprintf("SELECT COUNT(*) FROM (%s) AS counted;", q), args...)
+	observed(err)
+	return count, err
+}
+
+// getBuildingLevels returns the levels of a building's rooms from the bottom up, a level without a postfix
+// comes before the levels with one (0, 0M, 1). The lowest level that isn't below ground (or the top level
+// of buildings that are entirely below ground) is the entrance level.
+func getBuildingLevels(ctx context.Context, db *sqlx.DB, id int) ([]Level, error) {
+	var levels []Level
+	q := fmt.Sprintf(`
+		SELECT building, level, level_postfix, COUNT(*) AS room_count,
+			ST_XMin(ST_Extent(geometry)) AS min_lon, ST_YMin(ST_Extent(geometry)) AS min_lat,
+			ST_XMax(ST_Extent(geometry)) AS max_lon, ST_YMax(ST_Extent(geometry)) AS max_lat
+		FROM %s WHERE building=$1
+		GROUP BY building, level, level_postfix
+		ORDER BY level, level_postfix NULLS FIRST;
+	`, roomConfig.TableName)
+	observed := observeQuery("getBuildingLevels", roomConfig)
+	err := db.SelectContext(ctx, &levels, q, id)
+	observed(err)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range levels {
+		if levels[i].Level >= 0 {
+			levels[i].Entrance = true
+			return levels, nil
+		}
+	}
+	if len(levels) > 0 {
+		levels[len(levels)-1].Entrance = true
+	}
+	return levels, nil
+}
+
+// getLevelOutline returns the union of the rooms of a level in encoding
+func getLevelOutline(ctx context.Context, db *sqlx.DB, level Level, encoding geometryEncoding) (*string, error) {
+	var outline *string
+	q := fmt.Sprintf(`
+		SELECT %s FROM (
+			SELECT ST_Union(geometry) AS geometry FROM %s WHERE building=$1 AND level=$2 AND level_postfix IS NOT DISTINCT FROM $3
+		) AS level;
+	`, encoding.columnExpr(), roomConfig.TableName)
+	observed := observeQuery("getLevelOutline", roomConfig)
+	err := db.GetContext(ctx, &outline, q, level.Building, level.Level, level.LevelPostfix)
+	observed(err)
+	return outline, err
+}
+
+func getIntersectingRooms(ctx context.Context, db *sqlx.DB, filterConfig roomIntersectFilterConfig, id int) ([]Room, error) {
 	args := []interface{}{id}
 
 	qOptionalLevelFilter := ""
@@ -107,47 +240,777 @@ func getIntersectingRooms(db *sqlx.DB, filterConfig roomIntersectFilterConfig, i
 		)
 		SELECT %s FROM %s AS b WHERE id<>$1 AND ST_Intersects((select geometry from a), b.geometry) %s %s;
 	`, roomConfig.TableName, roomConfig.Columns, roomConfig.TableName, qOptionalLevelFilter, qOptionalLevelPostfixFilter)
-	err := db.Select(&rooms, q, args...)
+	observed := observeQuery("getIntersectingRooms", roomConfig)
+	err := db.SelectContext(ctx, &rooms, q, args...)
+	observed(err)
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("Found no rooms that intersect the given room")
+		return nil, notFoundError("", "Found no rooms that intersect the given room")
 	}
 	return rooms, err
 }
 
-const qAreaColumn = ", ST_Area(geometry) AS area"
-const qAreaFilter = "AND area BETWEEN $5 AND $6"
-const qSort = "ORDER BY"
+// getContainingBuilding returns the smallest building that contains the coordinates, or nil if there is none
+func getContainingBuilding(ctx context.Context, db *sqlx.DB, coordinates Coordinates) (*Building, error) {
+	var building Building
+	q := fmt.Sprintf(`
+		SELECT %s FROM %s WHERE ST_Contains(geometry, %s) ORDER BY ST_Area(geometry) LIMIT 1;
+	`, buildingConfig.Columns, buildingConfig.TableName, qPoint("$1", "$2"))
+	observed := observeQuery("getContainingBuilding", buildingConfig)
+	err := db.GetContext(ctx, &building, q, coordinates.Lon, coordinates.Lat)
+	observed(err)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &building, nil
+}
 
-func getFiltered(db *sqlx.DB, tableConfig TableConfig, filterConfig rootGeographyFilterConfig, dest interface{}) error {
-	qOptionalAreaColumn := ""
-	qOptionalAreaFilter := ""
-	var areaArgs []interface{}
-	if filterConfig.area.use {
-		qOptionalAreaColumn = qAreaColumn
-		qOptionalAreaFilter = qAreaFilter
-		areaArgs = []interface{}{filterConfig.area.filter.Min, filterConfig.area.filter.Max}
+// getLocatedRooms returns the rooms that contain or are near the coordinates, containing (and then smallest) rooms first
+func getLocatedRooms(ctx context.Context, db *sqlx.DB, filterConfig locateFilterConfig, buildingID *int) ([]LocatedRoom, error) {
+	c := filterConfig.coordinates
+	args := []interface{}{c.Lon, c.Lat, filterConfig.maxDistance}
+
+	qOptionalBuildingFilter := ""
+	if buildingID != nil {
+		qOptionalBuildingFilter = fmt.Sprintf("AND building = $%d", len(args)+1)
+		args = append(args, *buildingID)
+	}
+
+	qOptionalLevelFilter := ""
+	if filterConfig.level.use {
+		qOptionalLevelFilter = fmt.Sprintf("AND level = $%d", len(args)+1)
+		args = append(args, filterConfig.level.filter)
+	}
+
+	qOptionalLevelPostfixFilter := ""
+	if filterConfig.levelPostfix.use {
+		qOptionalLevelPostfixFilter = fmt.Sprintf("AND level_postfix = $%d", len(args)+1)
+		args = append(args, filterConfig.levelPostfix.filter)
 	}
 
-	qOptionalSort := ""
+	var rooms []LocatedRoom
+	q := fmt.Sprintf(`
+		SELECT %s, ST_Contains(geometry, %s) AS contains, %s AS distance
+		FROM %s WHERE %s %s %s %s
+		ORDER BY contains DESC, distance, ST_Area(geometry);
+	`, roomConfig.Columns, qPoint("$1", "$2"), qDistance(qPoint("$1", "$2")), roomConfig.TableName, qWithin(qPoint("$1", "$2"), "$3"),
+		qOptionalBuildingFilter, qOptionalLevelFilter, qOptionalLevelPostfixFilter)
+	observed := observeQuery("getLocatedRooms", roomConfig)
+	err := db.SelectContext(ctx, &rooms, q, args...)
+	observed(err)
+	return rooms, err
+}
+
+const qAreaColumn = ", ST_Area(geometry::geography) AS area"
+const qSort = "ORDER BY"
+
+// sortColumn returns the column the filtered elements are sorted on, or "" if they aren't sorted
+func (filterConfig rootGeographyFilterConfig) sortColumn() string {
 	if filterConfig.sortChoice.use {
 		switch filterConfig.sortChoice.filter {
 		case SortDistance:
-			qOptionalSort = fmt.Sprintf("%s distance", qSort)
+			return "distance"
 		case SortArea:
-			qOptionalSort = fmt.Sprintf("%s area", qSort)
+			return "area"
 		}
 	}
-	df := filterConfig.distanceFrom
-	args := append([]interface{}{df.Coordinates.Lon, df.Coordinates.Lat, df.Min, df.Max}, areaArgs...)
+	return ""
+}
+
+// withinShape returns the sql expression of the shape of a within filter and its args
+func withinShape(within Within, args []interface{}) (string, []interface{}) {
+	if within.BBox != nil {
+		args = append(args, within.BBox[0], within.BBox[1], within.BBox[2], within.BBox[3])
+		n := len(args)
+		return qEnvelope(fmt.Sprintf("$%d", n-3), fmt.Sprintf("$%d", n-2), fmt.Sprintf("$%d", n-1), fmt.Sprintf("$%d", n)), args
+	}
+	if within.GeoJSON != "" {
+		args = append(args, within.GeoJSON)
+		return fmt.Sprintf("ST_SetSRID(ST_GeomFromGeoJSON($%d), %d)", len(args), srid), args
+	}
+	args = append(args, within.WKT)
+	return geometryFromText(fmt.Sprintf("$%d", len(args))), args
+}
+
+// withinCondition returns the sql condition that the geometry column relates to the shape of a within filter
+// and its args
+func withinCondition(within Within, args []interface{}) (string, []interface{}) {
+	shape, args := withinShape(within, args)
+	if within.Relation == RelationContains {
+		return fmt.Sprintf("ST_Contains(%s, geometry)", shape), args
+	}
+	return fmt.Sprintf("ST_Intersects(geometry, %s)", shape), args
+}
+
+// validateWithinShape checks that the GeoJSON or WKT shape of a within filter parses to a valid geometry
+func validateWithinShape(ctx context.Context, db *sqlx.DB, within Within) error {
+	argument := "within.wkt"
+	if within.GeoJSON != "" {
+		argument = "within.geojson"
+	}
+	shape, args := withinShape(within, nil)
+	var reason string
+	err := db.GetContext(ctx, &reason, fmt.Sprintf("SELECT ST_IsValidReason(%s);", shape), args...)
+	if err != nil {
+		if pqErr, isPQErr := err.(*pq.Error); isPQErr {
+			return invalidArgumentError(argument, "invalid <%s>: %s", argument, pqErr.Message)
+		}
+		return err
+	}
+	if reason != "Valid Geometry" {
+		return invalidArgumentError(argument, "invalid <%s>: %s", argument, reason)
+	}
+	return nil
+}
+
+// filteredQuery returns the query (without order) for the elements matching filterConfig and its args.
+// Only the elements within the max distance and the within shape (found with the spatial indexes) are
+// measured, distance is null without a distanceFrom filter. The area column is only selected when
+// filtering or sorting on it.
+func filteredQuery(tableConfig TableConfig, filterConfig rootGeographyFilterConfig) (string, []interface{}) {
+	var args []interface{}
+	var conditions []string
+	var outerConditions []string
+
+	qDistanceColumn := "NULL::float8"
+	if filterConfig.distanceFrom.use {
+		df := filterConfig.distanceFrom.filter
+		args = append(args, df.Coordinates.Lon, df.Coordinates.Lat, df.Min, df.Max)
+		qDistanceColumn = qDistance(qPoint("$1", "$2"))
+		conditions = append(conditions, qWithin(qPoint("$1", "$2"), "$4"))
+		outerConditions = append(outerConditions, "distance >= $3")
+	}
+
+	if filterConfig.within.use {
+		var condition string
+		condition, args = withinCondition(filterConfig.within.filter, args)
+		conditions = append(conditions, condition)
+	}
+
+	qOptionalAreaColumn := ""
+	if filterConfig.area.use || filterConfig.sortColumn() == "area" {
+		qOptionalAreaColumn = qAreaColumn
+	}
+
+	if filterConfig.area.use {
+		area := filterConfig.area.filter
+		args = append(args, area.Min)
+		outerConditions = append(outerConditions, fmt.Sprintf("area >= $%d", len(args)))
+		if area.Max != nil {
+			args = append(args, *area.Max)
+			outerConditions = append(outerConditions, fmt.Sprintf("area <= $%d", len(args)))
+		}
+	}
+
+	// Pages append their keyset conditions to the outer where clause
+	if len(outerConditions) == 0 {
+		outerConditions = append(outerConditions, "TRUE")
+	}
+
 	q := fmt.Sprintf(`
 		SELECT * FROM (
-			SELECT %s, ST_Distance(ST_MakePoint($1, $2), geometry) as distance %s FROM %s
-		) AS ti WHERE distance BETWEEN $3 AND $4 %s %s;
-	`, tableConfig.Columns, qOptionalAreaColumn, tableConfig.TableName, qOptionalAreaFilter, qOptionalSort)
+			SELECT %s, %s as distance %s FROM %s WHERE %s
+		) AS ti WHERE %s
+	`, tableConfig.Columns, qDistanceColumn, qOptionalAreaColumn, tableConfig.TableName,
+		strings.Join(conditions, " AND "), strings.Join(outerConditions, " AND "))
+	return q, args
+}
 
-	err := db.Select(dest, q, args...)
+// getFiltered selects the first maxPageSize elements matching filterConfig into dest
+func getFiltered(ctx context.Context, db *sqlx.DB, tableConfig TableConfig, filterConfig rootGeographyFilterConfig, dest interface{}) error {
+	q, args := filteredQuery(tableConfig, filterConfig)
+
+	qSortColumns := "id"
+	if sortColumn := filterConfig.sortColumn(); sortColumn != "" {
+		qSortColumns = sortColumn + ", id"
+	}
+
+	observed := observeQuery("getFiltered", tableConfig)
+	err := db.SelectContext(ctx, dest, fmt.Sprintf("%s %s %s LIMIT %d;", q, qSort, qSortColumns, maxPageSize), args...)
+	observed(err)
 	if err == sql.ErrNoRows {
-		return fmt.Errorf("Found no %s for the specified filters, maybe broaden your search?", tableConfig.elementNamePlural())
+		return notFoundError("", "Found no %s for the specified filters, maybe broaden your search?", tableConfig.elementNamePlural())
+	}
+	return err
+}
+
+func getFilteredPage(ctx context.Context, db *sqlx.DB, tableConfig TableConfig, filterConfig rootGeographyFilterConfig, page pageConfig, dest interface{}) error {
+	q, args := filteredQuery(tableConfig, filterConfig)
+	qKeyset, args := page.keyset(filterConfig.sortColumn(), args)
+	observed := observeQuery("getFilteredPage", tableConfig)
+	err := db.SelectContext(ctx, dest, fmt.Sprintf("%s %s;", q, qKeyset), args...)
+	observed(err)
+	return err
+}
+
+func countFiltered(ctx context.Context, db *sqlx.DB, tableConfig TableConfig, filterConfig rootGeographyFilterConfig) (int, error) {
+	q, args := filteredQuery(tableConfig, filterConfig)
+	var count int
+	observed := observeQuery("countFiltered", tableConfig)
+	err := db.GetContext(ctx, &count, fmt.Sprintf("SELECT COUNT(*) FROM (%s) AS counted;", q), args...)
+	observed(err)
+	return count, err
+}
+
+// getNearest selects the limit elements of tableConfig nearest to the coordinates into dest, nearest first.
+// They are ordered with the KNN operator, which walks the GiST index on geometry::geography instead of
+// measuring every element.
+func getNearest(ctx context.Context, db *sqlx.DB, tableConfig TableConfig, coordinates Coordinates, limit int, dest interface{}) error {
+	point := qPoint("$1", "$2")
+	q := fmt.Sprintf(`
+		SELECT %s, %s AS distance FROM %s ORDER BY geometry::geography <-> (%s)::geography LIMIT $3;
+	`, tableConfig.Columns, qDistance(point), tableConfig.TableName, point)
+	observed := observeQuery("getNearest", tableConfig)
+	err := db.SelectContext(ctx, dest, q, coordinates.Lon, coordinates.Lat, limit)
+	observed(err)
+	return err
+}
+
+// getGazetteerCoordinates finds the building whose name or address best matches query
+func getGazetteerCoordinates(ctx context.Context, db *sqlx.DB, query string) (Coordinates, error) {
+	var coords Coordinates
+	q := fmt.Sprintf(`
+		SELECT ST_X(point) AS lon, ST_Y(point) AS lat FROM (
+			SELECT ST_PointOnSurface(b.geometry) AS point, b.name ILIKE $1 AS exact, length(b.name) AS name_length
+			FROM %s AS b LEFT JOIN %s AS a ON a.id = b.address
+			WHERE b.name ILIKE $2 OR a.free ILIKE $2
+		) AS matches ORDER BY exact DESC, name_length LIMIT 1;
+	`, buildingConfig.TableName, addressConfig.TableName)
+	err := db.GetContext(ctx, &coords, q, query, fmt.Sprintf("%%%s%%", query))
+	return coords, err
+}
+
+func getNavGraph(ctx context.Context, db *sqlx.DB) ([]NavNode, []NavEdge, error) {
+	var nodes []NavNode
+	err := db.SelectContext(ctx, &nodes, fmt.Sprintf("SELECT %s FROM %s;", navNodeConfig.Columns, navNodeConfig.TableName))
+	if err != nil {
+		return nil, nil, err
+	}
+	var edges []NavEdge
+	err = db.SelectContext(ctx, &edges, fmt.Sprintf("SELECT %s FROM %s;", navEdgeConfig.Columns, navEdgeConfig.TableName))
+	return nodes, edges, err
+}
+
+// roomAnchor is the point (on its level) a room is routed from or to
+type roomAnchor struct {
+	ID           int
+	Level        int
+	LevelPostfix *string `db:"level_postfix"`
+	Lon          float64
+	Lat          float64
+}
+
+func getRoomAnchor(ctx context.Context, db *sqlx.DB, uid string) (roomAnchor, error) {
+	var anchor roomAnchor
+	q := fmt.Sprintf(`
+		SELECT id, level, level_postfix, ST_X(ST_PointOnSurface(geometry)) AS lon, ST_Y(ST_PointOnSurface(geometry)) AS lat FROM %s WHERE uid=$1;
+	`, roomConfig.TableName)
+	err := db.GetContext(ctx, &anchor, q, uid)
+	if isNoRows(err) {
+		return anchor, notFoundError("room", "Found no %s with <room> (%s)", roomConfig.elementName(), uid)
 	}
+	return anchor, err
+}
+
+var errNoGeocodeCacheEntry = fmt.Errorf("Found no unexpired %s", geocodeCacheConfig.elementName())
+
+func ensureGeocodeCacheTable(ctx context.Context, db *sqlx.DB) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			query text PRIMARY KEY,
+			lon double precision NOT NULL,
+			lat double precision NOT NULL,
+			found boolean NOT NULL,
+			expires_at timestamptz NOT NULL
+		);
+	`, geocodeCacheConfig.TableName))
 	return err
 }
+
+func getGeocodeCacheEntry(ctx context.Context, db *sqlx.DB, query string) (geocodeCacheEntry, error) {
+	var entry geocodeCacheEntry
+	err := db.GetContext(ctx, &entry, fmt.Sprintf("SELECT %s FROM %s WHERE query=$1 AND expires_at > now();", geocodeCacheConfig.Columns, geocodeCacheConfig.TableName), query)
+	if err == sql.ErrNoRows {
+		return entry, errNoGeocodeCacheEntry
+	}
+	return entry, err
+}
+
+func putGeocodeCacheEntry(ctx context.Context, db *sqlx.DB, entry geocodeCacheEntry) error {
+	_, err := db.NamedExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO %s (%s) VALUES (:query, :lon, :lat, :found, :expires_at)
+		ON CONFLICT (query) DO UPDATE SET lon = EXCLUDED.lon, lat = EXCLUDED.lat, found = EXCLUDED.found, expires_at = EXCLUDED.expires_at;
+	`, geocodeCacheConfig.TableName, geocodeCacheConfig.Columns), entry)
+	return err
+}
+
+func ensurePersistedQueryTable(ctx context.Context, db *sqlx.DB) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			hash text PRIMARY KEY,
+			query text NOT NULL,
+			created_at timestamptz NOT NULL DEFAULT now()
+		);
+	`, persistedQueryConfig.TableName))
+	return err
+}
+
+// getPersistedQuery returns false (and no error) if there is no persisted query with hash
+func getPersistedQuery(ctx context.Context, db *sqlx.DB, hash string) (string, bool, error) {
+	var query string
+	err := db.GetContext(ctx, &query, fmt.Sprintf("SELECT query FROM %s WHERE hash=$1;", persistedQueryConfig.TableName), hash)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	return query, err == nil, err
+}
+
+func putPersistedQuery(ctx context.Context, db *sqlx.DB, hash string, query string) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO %s (%s) VALUES ($1, $2) ON CONFLICT (hash) DO NOTHING;
+	`, persistedQueryConfig.TableName, persistedQueryConfig.Columns), hash, query)
+	return err
+}
+
+// ogcItemsQuery returns the query (without order and paging) selecting columns of the features of collection
+// matching filter and its args
+func ogcItemsQuery(collection ogcCollection, columns string, filter ogcItemsFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if filter.bbox != nil {
+		conditions = append(conditions, fmt.Sprintf("ST_Intersects(%s, %s)", collection.geometry, qEnvelope("$1", "$2", "$3", "$4")))
+		args = append(args, filter.bbox[0], filter.bbox[1], filter.bbox[2], filter.bbox[3])
+	}
+	for _, property := range filter.properties {
+		args = append(args, property.value)
+		conditions = append(conditions, fmt.Sprintf(property.filter.condition, fmt.Sprintf("$%d", len(args))))
+	}
+
+	qOptionalWhere := ""
+	if len(conditions) > 0 {
+		qOptionalWhere = "WHERE " + strings.Join(conditions, " AND ")
+	}
+	q := fmt.Sprintf(`
+		SELECT %s FROM %s %s
+	`, columns, collection.tableConfig.TableName, qOptionalWhere)
+	return q, args
+}
+
+func getOGCFeatures(ctx context.Context, db *sqlx.DB, collection ogcCollection, filter ogcItemsFilter) ([]ogcFeature, error) {
+	q, args := ogcItemsQuery(collection, collection.featureConfig().Columns, filter)
+	args = append(args, filter.limit, filter.offset)
+	return collection.features(ctx, db, fmt.Sprintf("%s ORDER BY id LIMIT $%d OFFSET $%d;", q, len(args)-1, len(args)), args)
+}
+
+func countOGCFeatures(ctx context.Context, db *sqlx.DB, collection ogcCollection, filter ogcItemsFilter) (int, error) {
+	q, args := ogcItemsQuery(collection, "COUNT(*)", filter)
+	var count int
+	err := db.GetContext(ctx, &count, q+";", args...)
+	return count, err
+}
+
+// getOGCFeature returns nil if the collection has no feature with id (parsed with the collection's idKind)
+func getOGCFeature(ctx context.Context, db *sqlx.DB, collection ogcCollection, id interface{}) (*ogcFeature, error) {
+	featureConfig := collection.featureConfig()
+	features, err := collection.features(ctx, db, fmt.Sprintf(`
+		SELECT %s FROM %s WHERE %s = $1;
+	`, featureConfig.Columns, featureConfig.TableName, collection.idColumn), []interface{}{id})
+	if err != nil || len(features) == 0 {
+		return nil, err
+	}
+	return &features[0], nil
+}
+
+// getOGCExtent returns the bounding box of all features of collection, nil if none has a geometry
+func getOGCExtent(ctx context.Context, db *sqlx.DB, collection ogcCollection) ([]float64, error) {
+	var extent struct {
+		MinX *float64 `db:"min_x"`
+		MinY *float64 `db:"min_y"`
+		MaxX *float64 `db:"max_x"`
+		MaxY *float64 `db:"max_y"`
+	}
+	err := db.GetContext(ctx, &extent, fmt.Sprintf(`
+		SELECT ST_XMin(extent) AS min_x, ST_YMin(extent) AS min_y, ST_XMax(extent) AS max_x, ST_YMax(extent) AS max_y
+		FROM (SELECT ST_Extent(%s) AS extent FROM %s) AS extents;
+	`, collection.geometry, collection.tableConfig.TableName))
+	if err != nil || extent.MinX == nil {
+		return nil, err
+	}
+	return []float64{*extent.MinX, *extent.MinY, *extent.MaxX, *extent.MaxY}, nil
+}
+
+// getTile returns the buildings and rooms layers of the tile as a Mapbox vector tile.
+// Rows are found on the (buffered) lon/lat bounds of the tile so the spatial indexes are used.
+func getTile(ctx context.Context, db *sqlx.DB, tile tileID) ([]byte, error) {
+	m := tile.mercatorBounds()
+	l := tile.lonLatBounds()
+	args := []interface{}{m[0], m[1], m[2], m[3], l[0], l[1], l[2], l[3]}
+
+	qRoomsLayer := "''::bytea"
+	if tile.z >= tileMinRoomZoom {
+		qOptionalLevelFilter := ""
+		if tile.level.use {
+			qOptionalLevelFilter = fmt.Sprintf("AND level = $%d", len(args)+1)
+			args = append(args, tile.level.filter)
+		}
+		qRoomsLayer = fmt.Sprintf(`(
+			SELECT ST_AsMVT(layer, 'rooms', %d, 'geom') FROM (
+				SELECT ST_AsMVTGeom(ST_Transform(geometry, 3857), bounds.geom, %d, %d, true) AS geom,
+					uid::text AS uid, name, ref, level, level_postfix
+				FROM %s, bounds WHERE geometry && %s %s
+			) AS layer
+		)`, tileExtent, tileExtent, tileBuffer, roomConfig.TableName, qEnvelope("$5", "$6", "$7", "$8"), qOptionalLevelFilter)
+	}
+
+	q := fmt.Sprintf(`
+		WITH bounds AS (
+			SELECT ST_MakeEnvelope($1, $2, $3, $4, 3857) AS geom
+		)
+		SELECT COALESCE((
+			SELECT ST_AsMVT(layer, 'buildings', %d, 'geom') FROM (
+				SELECT ST_AsMVTGeom(ST_Transform(geometry, 3857), bounds.geom, %d, %d, true) AS geom,
+					uid::text AS uid, name
+				FROM %s, bounds WHERE geometry && %s
+			) AS layer
+		), ''::bytea) || COALESCE(%s, ''::bytea);
+	`, tileExtent, tileExtent, tileBuffer, buildingConfig.TableName, qEnvelope("$5", "$6", "$7", "$8"), qRoomsLayer)
+	var mvt []byte
+	err := db.GetContext(ctx, &mvt, q, args...)
+	return mvt, err
+}
+
+// searchScoredQuery scores the rows of candidates (a query with a distance column) on how well their doc
+// expression matches the search query ($1), combining trigram word similarity and full-text rank
+// (both accent and case insensitive). The score is lowered the further away the row is.
+func searchScoredQuery(candidates string, doc string, limit int) string {
+	return fmt.Sprintf(`
+		WITH search AS (
+			SELECT lower(unaccent($1)) AS text, plainto_tsquery('simple', lower(unaccent($1))) AS query
+		), docs AS (
+			SELECT *, lower(unaccent(%s)) AS doc FROM (%s) AS candidates
+		)
+		SELECT docs.*, (word_similarity(search.text, doc) + ts_rank(to_tsvector('simple', doc), search.query)) / (1 + distance / %d) AS score
+		FROM docs, search
+		WHERE search.text <%% doc OR to_tsvector('simple', doc) @@ search.query
+		ORDER BY score DESC LIMIT %d;
+	`, doc, candidates, searchNearScale, limit)
+}
+
+// searchDistanceColumn returns the distance column of the search candidates and its args
+func searchDistanceColumn(filterConfig searchFilterConfig, args []interface{}) (string, []interface{}) {
+	if filterConfig.near == nil {
+		return "0::float8 AS distance", args
+	}
+	args = append(args, filterConfig.near.Lon, filterConfig.near.Lat)
+	point := qPoint(fmt.Sprintf("$%d", len(args)-1), fmt.Sprintf("$%d", len(args)))
+	return qDistance(point) + " AS distance", args
+}
+
+func searchRooms(ctx context.Context, db *sqlx.DB, filterConfig searchFilterConfig) ([]searchRoomRow, error) {
+	qDistanceColumn, args := searchDistanceColumn(filterConfig, []interface{}{filterConfig.query})
+	candidates := fmt.Sprintf(`
+		SELECT * FROM (SELECT %s, %s FROM %s) AS r
+		LEFT JOIN (SELECT id AS building_id, name AS building_name FROM %s) AS b ON b.building_id = r.building
+	`, roomConfig.Columns, qDistanceColumn, roomConfig.TableName, buildingConfig.TableName)
+	q := searchScoredQuery(candidates, "concat_ws(' ', name, ref, building_name)", filterConfig.limit)
+
+	var rooms []searchRoomRow
+	observed := observeQuery("search", roomConfig)
+	// Unsafe because the helper columns (doc, building_id, distance) have no destination
+	err := db.Unsafe().SelectContext(ctx, &rooms, q, args...)
+	observed(err)
+	return rooms, err
+}
+
+func searchBuildings(ctx context.Context, db *sqlx.DB, filterConfig searchFilterConfig) ([]searchBuildingRow, error) {
+	qDistanceColumn, args := searchDistanceColumn(filterConfig, []interface{}{filterConfig.query})
+	candidates := fmt.Sprintf(`
+		SELECT * FROM (SELECT %s, %s FROM %s) AS b
+		LEFT JOIN (
+			SELECT id AS address_id, free AS address_free, locality AS address_locality, postcode AS address_postcode FROM %s
+		) AS a ON a.address_id = b.address
+	`, buildingConfig.Columns, qDistanceColumn, buildingConfig.TableName, addressConfig.TableName)
+	q := searchScoredQuery(candidates, "concat_ws(' ', name, address_free, address_locality, address_postcode)", filterConfig.limit)
+
+	var buildings []searchBuildingRow
+	observed := observeQuery("search", buildingConfig)
+	err := db.Unsafe().SelectContext(ctx, &buildings, q, args...)
+	observed(err)
+	return buildings, err
+}
+
+// inTx runs fn in a transaction that is committed if fn succeeds and rolled back otherwise
+func inTx(ctx context.Context, db *sqlx.DB, fn func(tx *sqlx.Tx) error) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// geometryFromText returns the sql expression that parses the WKT placeholder in srid
+func geometryFromText(placeholder string) string {
+	return fmt.Sprintf("ST_GeomFromText(%s, %d)", placeholder, srid)
+}
+
+func validateGeometry(ctx context.Context, tx *sqlx.Tx, wkt string) error {
+	var reason string
+	err := tx.GetContext(ctx, &reason, "SELECT ST_IsValidReason(ST_GeomFromText($1));", wkt)
+	if err != nil {
+		if pqErr, isPQErr := err.(*pq.Error); isPQErr {
+			return invalidArgumentError("geometry", "invalid <geometry>: %s", pqErr.Message)
+		}
+		return err
+	}
+	if reason != "Valid Geometry" {
+		return invalidArgumentError("geometry", "invalid <geometry>: %s", reason)
+	}
+	return nil
+}
+
+// createSurveyDataSource records an edit as a new data source of the survey and returns its id
+func createSurveyDataSource(ctx context.Context, tx *sqlx.Tx, surveyUID string) (int, error) {
+	var survey Survey
+	if err := getByUID(ctx, tx, surveyConfig, "survey", surveyUID, &survey); err != nil {
+		return 0, err
+	}
+	var id int
+	err := tx.GetContext(ctx, &id, fmt.Sprintf("INSERT INTO %s (survey) VALUES ($1) RETURNING id;", dataSourceConfig.TableName), survey.ID)
+	return id, err
+}
+
+// lockVersion locks the element for the rest of the transaction and returns its id,
+// if it is still at the version the edit was based on
+func lockVersion(ctx context.Context, tx *sqlx.Tx, tableConfig TableConfig, version elementVersion) (int, error) {
+	var current struct {
+		ID      int
+		Version int
+	}
+	err := tx.GetContext(ctx, &current, fmt.Sprintf("SELECT id, version FROM %s WHERE uid=$1 FOR UPDATE;", tableConfig.TableName), version.uid)
+	if isNoRows(err) {
+		return 0, notFoundError("uid", "Found no %s with <uid> (%s)", tableConfig.elementName(), version.uid)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if current.Version != version.version {
+		return 0, invalidArgumentError("version", "%s (%s) was changed concurrently: <version> (%d) is not the current version (%d), reload it and try again",
+			tableConfig.elementName(), version.uid, version.version, current.Version)
+	}
+	return current.ID, nil
+}
+
+func getInTx(ctx context.Context, tx *sqlx.Tx, tableConfig TableConfig, id int, dest interface{}) error {
+	return tx.GetContext(ctx, dest, fmt.Sprintf("SELECT %s FROM %s WHERE id=$1;", tableConfig.Columns, tableConfig.TableName), id)
+}
+
+func createSurvey(ctx context.Context, db *sqlx.DB, survey Survey) (Survey, error) {
+	err := db.GetContext(ctx, &survey.ID, fmt.Sprintf("INSERT INTO %s (uid, surveyor, external) VALUES ($1, $2, $3) RETURNING id;", surveyConfig.TableName),
+		survey.UID, survey.Surveyor, survey.External)
+	return survey, err
+}
+
+// roomColumnValues returns the columns a room edit sets
+func roomColumnValues(ctx context.Context, tx *sqlx.Tx, edit roomEdit) (columnValues, error) {
+	var values columnValues
+	if edit.geometry.use {
+		if err := validateGeometry(ctx, tx, edit.geometry.filter); err != nil {
+			return values, err
+		}
+		values.set("geometry", geometryFromText(values.param(edit.geometry.filter)))
+	}
+	if edit.building.use {
+		var building Building
+		if err := getByUID(ctx, tx, buildingConfig, "building", edit.building.filter, &building); err != nil {
+			return values, err
+		}
+		values.set("building", values.param(building.ID))
+	}
+	if edit.name.use {
+		values.set("name", values.param(edit.name.filter))
+	}
+	if edit.level.use {
+		values.set("level", values.param(edit.level.filter))
+	}
+	if edit.levelPostfix.use {
+		values.set("level_postfix", values.param(edit.levelPostfix.filter))
+	}
+	if edit.ref.use {
+		values.set("ref", values.param(edit.ref.filter))
+	}
+	return values, nil
+}
+
+func createRoom(ctx context.Context, db *sqlx.DB, surveyUID string, edit roomEdit) (Room, error) {
+	var room Room
+	err := inTx(ctx, db, func(tx *sqlx.Tx) error {
+		values, err := roomColumnValues(ctx, tx, edit)
+		if err != nil {
+			return err
+		}
+		dataSource, err := createSurveyDataSource(ctx, tx, surveyUID)
+		if err != nil {
+			return err
+		}
+		values.set("uid", values.param(newUID()))
+		values.set("data_source", values.param(dataSource))
+		values.set("version", "1")
+
+		var id int
+		if err := tx.GetContext(ctx, &id, values.insertQuery(roomConfig), values.args...); err != nil {
+			return err
+		}
+		return getInTx(ctx, tx, roomConfig, id, &room)
+	})
+	return room, err
+}
+
+func updateRoom(ctx context.Context, db *sqlx.DB, surveyUID string, version elementVersion, edit roomEdit) (Room, error) {
+	var room Room
+	err := inTx(ctx, db, func(tx *sqlx.Tx) error {
+		id, err := lockVersion(ctx, tx, roomConfig, version)
+		if err != nil {
+			return err
+		}
+		values, err := roomColumnValues(ctx, tx, edit)
+		if err != nil {
+			return err
+		}
+		dataSource, err := createSurveyDataSource(ctx, tx, surveyUID)
+		if err != nil {
+			return err
+		}
+		values.set("data_source", values.param(dataSource))
+		values.set("version", "version + 1")
+
+		if _, err := tx.ExecContext(ctx, values.updateQuery(roomConfig, id), values.args...); err != nil {
+			return err
+		}
+		return getInTx(ctx, tx, roomConfig, id, &room)
+	})
+	return room, err
+}
+
+// deleteRoom deletes the room, recording the deletion (and the room as it was) as a tombstone with a new data
+// source of the survey, and returns the room as it was
+func deleteRoom(ctx context.Context, db *sqlx.DB, surveyUID string, version elementVersion) (Room, error) {
+	var room Room
+	err := inTx(ctx, db, func(tx *sqlx.Tx) error {
+		id, err := lockVersion(ctx, tx, roomConfig, version)
+		if err != nil {
+			return err
+		}
+		if err := getInTx(ctx, tx, roomConfig, id, &room); err != nil {
+			return err
+		}
+		dataSource, err := createSurveyDataSource(ctx, tx, surveyUID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(`
+			INSERT INTO %s (uid, version, data_source, room) SELECT uid, version, $2, to_jsonb(r) FROM %s AS r WHERE id=$1;
+		`, deletedRoomConfig.TableName, roomConfig.TableName), id, dataSource)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE id=$1;", roomConfig.TableName), id)
+		return err
+	})
+	return room, err
+}
+
+// buildingColumnValues returns the columns a building edit sets, besides its address
+func buildingColumnValues(ctx context.Context, tx *sqlx.Tx, edit buildingEdit) (columnValues, error) {
+	var values columnValues
+	if edit.geometry.use {
+		if err := validateGeometry(ctx, tx, edit.geometry.filter); err != nil {
+			return values, err
+		}
+		values.set("geometry", geometryFromText(values.param(edit.geometry.filter)))
+	}
+	if edit.name.use {
+		values.set("name", values.param(edit.name.filter))
+	}
+	return values, nil
+}
+
+func addressColumnValues(address Address) columnValues {
+	var values columnValues
+	values.set("free", values.param(address.Free))
+	values.set("locality", values.param(address.Locality))
+	values.set("region", values.param(address.Region))
+	values.set("postcode", values.param(address.Postcode))
+	values.set("country", values.param(address.Country))
+	return values
+}
+
+func createBuilding(ctx context.Context, db *sqlx.DB, surveyUID string, edit buildingEdit) (Building, error) {
+	var building Building
+	err := inTx(ctx, db, func(tx *sqlx.Tx) error {
+		values, err := buildingColumnValues(ctx, tx, edit)
+		if err != nil {
+			return err
+		}
+		addressValues := addressColumnValues(*edit.address)
+		var address int
+		if err := tx.GetContext(ctx, &address, addressValues.insertQuery(addressConfig), addressValues.args...); err != nil {
+			return err
+		}
+		dataSource, err := createSurveyDataSource(ctx, tx, surveyUID)
+		if err != nil {
+			return err
+		}
+		values.set("uid", values.param(newUID()))
+		values.set("address", values.param(address))
+		values.set("data_source", values.param(dataSource))
+		values.set("version", "1")
+
+		var id int
+		if err := tx.GetContext(ctx, &id, values.insertQuery(buildingConfig), values.args...); err != nil {
+			return err
+		}
+		return getInTx(ctx, tx, buildingConfig, id, &building)
+	})
+	return building, err
+}
+
+func updateBuilding(ctx context.Context, db *sqlx.DB, surveyUID string, version elementVersion, edit buildingEdit) (Building, error) {
+	var building Building
+	err := inTx(ctx, db, func(tx *sqlx.Tx) error {
+		id, err := lockVersion(ctx, tx, buildingConfig, version)
+		if err != nil {
+			return err
+		}
+		values, err := buildingColumnValues(ctx, tx, edit)
+		if err != nil {
+			return err
+		}
+		if edit.address != nil {
+			if err := getInTx(ctx, tx, buildingConfig, id, &building); err != nil {
+				return err
+			}
+			addressValues := addressColumnValues(*edit.address)
+			if _, err := tx.ExecContext(ctx, addressValues.updateQuery(addressConfig, building.Address), addressValues.args...); err != nil {
+				return err
+			}
+		}
+		dataSource, err := createSurveyDataSource(ctx, tx, surveyUID)
+		if err != nil {
+			return err
+		}
+		values.set("data_source", values.param(dataSource))
+		values.set("version", "version + 1")
+
+		if _, err := tx.ExecContext(ctx, values.updateQuery(buildingConfig, id), values.args...); err != nil {
+			return err
+		}
+		return getInTx(ctx, tx, buildingConfig, id, &building)
+	})
+	return building, err
+}
@@ -1,6 +1,9 @@
 package api
 
-import "time"
+import (
+	"strconv"
+	"time"
+)
 
 // TableConfig represents a table in the sql data structure
 type TableConfig struct {
@@ -37,6 +40,14 @@ var surveyConfig = TableConfig{
 	Columns:   "id, uid, surveyor, external",
 }
 
+// deletedRoomConfig is the table of room tombstones: the uid, version and row of a deleted room and the
+// data source that deleted it
+var deletedRoomConfig = TableConfig{
+	TableName:   "deleted_room",
+	ElementName: "deleted room",
+	Columns:     "uid, version, data_source, room, deleted_at",
+}
+
 // OsmElement represents an sql osm_element
 type OsmElement struct {
 	ID         int
@@ -70,7 +81,7 @@ type DataSource struct {
 	ID     int
 	Osm    *int `json:"osm"`
 	Survey *int `json:"survey"`
-	Import int  `json:"import"`
+	Import *int `json:"import"`
 }
 
 var dataSourceConfig = TableConfig{
@@ -102,11 +113,12 @@ type Building struct {
 	Geometry   string  `json:"geometry"`
 	Address    int     `json:"address"`
 	DataSource int     `json:"dataSource" db:"data_source"`
+	Version    int     `json:"version"`
 }
 
 var buildingConfig = TableConfig{
 	TableName: "building",
-	Columns:   "id, uid, name, ST_AsText(geometry) as geometry, address, data_source",
+	Columns:   "id, uid, name, ST_AsText(geometry) as geometry, address, data_source, version",
 }
 
 // Room represents an sql room
@@ -120,9 +132,80 @@ type Room struct {
 	Ref          *string `json:"ref"`
 	Building     int     `json:"building"`
 	DataSource   int     `json:"dataSource" db:"data_source"`
+	Version      int     `json:"version"`
 }
 
 var roomConfig = TableConfig{
 	TableName: "room",
-	Columns:   "id, uid, name, ST_AsText(geometry) as geometry, level, level_postfix, ref, building, data_source",
+	Columns:   "id, uid, name, ST_AsText(geometry) as geometry, level, level_postfix, ref, building, data_source, version",
+}
+
+// Level represents a level of a building, aggregated from its rooms
+type Level struct {
+	Building     int
+	Level        int     `json:"level"`
+	LevelPostfix *string `json:"levelPostfix" db:"level_postfix"`
+	RoomCount    int     `json:"roomCount" db:"room_count"`
+	BoundingBox
+	Entrance bool `json:"isEntrance" db:"-"`
+}
+
+// name returns the level's display name, e.g. "Ground floor", "-1" or "0M"
+func (level Level) name() string {
+	if level.LevelPostfix == nil {
+		if level.Level == 0 {
+			return "Ground floor"
+		}
+		return strconv.Itoa(level.Level)
+	}
+	return strconv.Itoa(level.Level) + *level.LevelPostfix
+}
+
+// NavNode represents an sql nav_node, a point in the indoor navigation graph
+type NavNode struct {
+	ID           int
+	Lon          float64
+	Lat          float64
+	Level        int
+	LevelPostfix *string `db:"level_postfix"`
+	Room         *int
+	Kind         string
+}
+
+func (node NavNode) coordinates() Coordinates {
+	return Coordinates{node.Lon, node.Lat}
+}
+
+var navNodeConfig = TableConfig{
+	TableName:   "nav_node",
+	ElementName: "navigation node",
+	Columns:     "id, ST_X(geometry) AS lon, ST_Y(geometry) AS lat, level, level_postfix, room, kind",
+}
+
+// NavEdge represents an sql nav_edge, a walkable connection between two navigation nodes
+type NavEdge struct {
+	ID       int
+	FromNode int `db:"from_node"`
+	ToNode   int `db:"to_node"`
+	Kind     string
+	Length   *float64
+	Oneway   bool
+}
+
+var navEdgeConfig = TableConfig{
+	TableName:   "nav_edge",
+	ElementName: "navigation edge",
+	Columns:     "id, from_node, to_node, kind, length, oneway",
+}
+
+var geocodeCacheConfig = TableConfig{
+	TableName:   "geocode_cache",
+	ElementName: "geocode cache entry",
+	Columns:     "query, lon, lat, found, expires_at",
+}
+
+var persistedQueryConfig = TableConfig{
+	TableName:   "persisted_query",
+	ElementName: "persisted query",
+	Columns:     "hash, query",
 }
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/lib/pq"
+)
+
+// Postgres channels the change triggers notify on
+const (
+	roomChangedChannel     = "room_changed"
+	buildingChangedChannel = "building_changed"
+	importCompletedChannel = "import_completed"
+)
+
+// Change kinds
+const (
+	changeCreated = "CREATED"
+	changeUpdated = "UPDATED"
+	changeDeleted = "DELETED"
+)
+
+// subscriberBuffer is the number of events a subscriber can lag behind before events are dropped
+const subscriberBuffer = 64
+
+// ChangeEvent is a notification of a changed row, building is the uid of a room's building
+type ChangeEvent struct {
+	Channel  string  `json:"-"`
+	Kind     string  `json:"kind"`
+	ID       int     `json:"id"`
+	UID      string  `json:"uid"`
+	Building *string `json:"building"`
+}
+
+// changeHub listens for change notifications and fans them out to all subscribers
+type changeHub struct {
+	listener *pq.Listener
+
+	mu          sync.Mutex
+	subscribers map[chan ChangeEvent]struct{}
+}
+
+func newChangeHub(config DBConfig) (*changeHub, error) {
+	logEvent := func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("change listener: %v", err)
+		}
+	}
+	listener := pq.NewListener(config.connString(), time.Second, time.Minute, logEvent)
+	for _, channel := range []string{roomChangedChannel, buildingChangedChannel, importCompletedChannel} {
+		if err := listener.Listen(channel); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+
+	hub := &changeHub{
+		listener:    listener,
+		subscribers: map[chan ChangeEvent]struct{}{},
+	}
+	go hub.run()
+	return hub, nil
+}
+
+func (hub *changeHub) run() {
+	for notification := range hub.listener.Notify {
+		// nil after a reconnect, notifications sent in the meantime are lost
+		if notification == nil {
+			continue
+		}
+		event := ChangeEvent{Channel: notification.Channel}
+		if err := json.Unmarshal([]byte(notification.Extra), &event); err != nil {
+			log.Printf("invalid %s notification (%s): %v", notification.Channel, notification.Extra, err)
+			continue
+		}
+		hub.publish(event)
+	}
+}
+
+func (hub *changeHub) publish(event ChangeEvent) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for subscriber := range hub.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			log.Printf("dropped %s event for a slow subscriber", event.Channel)
+		}
+	}
+}
+
+// subscribe returns a channel that receives every change event until unsubscribe is called
+func (hub *changeHub) subscribe() (<-chan ChangeEvent, func()) {
+	subscriber := make(chan ChangeEvent, subscriberBuffer)
+	hub.mu.Lock()
+	hub.subscribers[subscriber] = struct{}{}
+	hub.mu.Unlock()
+
+	unsubscribe := func() {
+		hub.mu.Lock()
+		defer hub.mu.Unlock()
+		if _, subscribed := hub.subscribers[subscriber]; subscribed {
+			delete(hub.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+	return subscriber, unsubscribe
+}
+
+func (hub *changeHub) Close() error {
+	return hub.listener.Close()
+}
+
+// subscriptionExecution is the execution of a subscription operation for a single event.
+// Root subscription fields mark it as matched when the event is one they subscribe to.
+type subscriptionExecution struct {
+	event   *ChangeEvent
+	matched bool
+}
+
+// subscriptionRoot returns the root value to execute a subscription operation for event
+// (nil to only check the operation)
+func subscriptionRoot(execution *subscriptionExecution) map[string]interface{} {
+	return map[string]interface{}{"execution": execution}
+}
+
+// subscriptionEvent returns the event a root subscription field is executed for if it is on channel and matches,
+// nil otherwise
+func subscriptionEvent(params graphql.ResolveParams, channel string, matches func(event ChangeEvent) bool) *ChangeEvent {
+	root, _ := params.Info.RootValue.(map[string]interface{})
+	execution, _ := root["execution"].(*subscriptionExecution)
+	if execution == nil || execution.event == nil {
+		return nil
+	}
+	event := execution.event
+	if event.Channel != channel || !matches(*event) {
+		return nil
+	}
+	execution.matched = true
+	return event
+}
@@ -0,0 +1,252 @@
+package api
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const tileMaxZoom = 22
+
+// tileMinRoomZoom is the lowest zoom level whose tiles have the rooms layer, lower zoom levels would
+// hold the rooms of entire cities
+const tileMinRoomZoom = 14
+
+// tileExtent is the size of a tile in tile coordinates
+const tileExtent = 4096
+
+// tileBuffer is how far (in tile coordinates) geometries are kept outside a tile, so outlines aren't cut at its edges
+const tileBuffer = 64
+
+// webMercatorHalfSize is half the width of the web mercator (EPSG:3857) world in meters
+const webMercatorHalfSize = 20037508.342789244
+
+// tileID is a tile of the web mercator tile grid with an optional level filter on its rooms
+type tileID struct {
+	z     int
+	x     int
+	y     int
+	level optionalIntFilter
+}
+
+func (tile tileID) key() string {
+	if tile.level.use {
+		return fmt.Sprintf("%d/%d/%d?level=%d", tile.z, tile.x, tile.y, tile.level.filter)
+	}
+	return fmt.Sprintf("%d/%d/%d", tile.z, tile.x, tile.y)
+}
+
+// mercatorBounds returns the tile's min x, min y, max x and max y in web mercator meters
+func (tile tileID) mercatorBounds() [4]float64 {
+	size := 2 * webMercatorHalfSize / math.Exp2(float64(tile.z))
+	minX := -webMercatorHalfSize + float64(tile.x)*size
+	maxY := webMercatorHalfSize - float64(tile.y)*size
+	return [4]float64{minX, maxY - size, minX + size, maxY}
+}
+
+// lonLatBounds returns the tile's min lon, min lat, max lon and max lat, grown by the tile buffer
+func (tile tileID) lonLatBounds() [4]float64 {
+	n := math.Exp2(float64(tile.z))
+	buffer := float64(tileBuffer) / tileExtent
+	lon := func(x float64) float64 {
+		return x/n*360 - 180
+	}
+	lat := func(y float64) float64 {
+		return math.Atan(math.Sinh(math.Pi*(1-2*y/n))) * 180 / math.Pi
+	}
+	x, y := float64(tile.x), float64(tile.y)
+	return [4]float64{lon(x - buffer), lat(y + 1 + buffer), lon(x + 1 + buffer), lat(y - buffer)}
+}
+
+// parseTilePath parses a /tiles/{z}/{x}/{y}.mvt path
+func parseTilePath(path string) (tileID, error) {
+	var tile tileID
+	parts := strings.Split(strings.TrimPrefix(path, "/tiles/"), "/")
+	if len(parts) != 3 || !strings.HasSuffix(parts[2], ".mvt") {
+		return tile, fmt.Errorf("tile path (%s) must be /tiles/{z}/{x}/{y}.mvt", path)
+	}
+	parts[2] = strings.TrimSuffix(parts[2], ".mvt")
+
+	var coords [3]int
+	for i, part := range parts {
+		coord, err := strconv.Atoi(part)
+		if err != nil {
+			return tile, fmt.Errorf("tile path (%s) must be /tiles/{z}/{x}/{y}.mvt", path)
+		}
+		coords[i] = coord
+	}
+	tile.z, tile.x, tile.y = coords[0], coords[1], coords[2]
+
+	if tile.z < 0 || tile.z > tileMaxZoom {
+		return tile, fmt.Errorf("zoom (%d) must be between 0 and %d", tile.z, tileMaxZoom)
+	}
+	n := 1 << uint(tile.z)
+	if tile.x < 0 || tile.x >= n || tile.y < 0 || tile.y >= n {
+		return tile, fmt.Errorf("x (%d) and y (%d) must be between 0 and %d at zoom %d", tile.x, tile.y, n-1, tile.z)
+	}
+	return tile, nil
+}
+
+// cachedTile is an encoded tile and its ETag
+type cachedTile struct {
+	key  string
+	mvt  []byte
+	etag string
+}
+
+// tileCache is an in-memory, fixed size tile cache that evicts the least recently used tile.
+// It is cleared whenever a room or building changes, which starts a new generation: tiles rendered
+// in an earlier generation (before the change) aren't cached anymore.
+type tileCache struct {
+	mu         sync.Mutex
+	size       int
+	order      *list.List
+	entries    map[string]*list.Element
+	generation uint64
+}
+
+func newTileCache(size int) *tileCache {
+	return &tileCache{
+		size:    size,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+func (c *tileCache) get(key string) (cachedTile, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, exists := c.entries[key]
+	if !exists {
+		return cachedTile{}, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(cachedTile), true
+}
+
+// currentGeneration returns the generation to pass to put for a tile that is rendered now
+func (c *tileCache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// put caches a tile rendered in generation, unless the cache has been cleared since
+func (c *tileCache) put(tile cachedTile, generation uint64) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	if element, exists := c.entries[tile.key]; exists {
+		element.Value = tile
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[tile.key] = c.order.PushFront(tile)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(cachedTile).key)
+	}
+}
+
+func (c *tileCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = map[string]*list.Element{}
+	c.generation++
+}
+
+// clearOnChanges clears the cache for every room and building change until events is closed
+func (c *tileCache) clearOnChanges(events <-chan ChangeEvent) {
+	for event := range events {
+		if event.Channel == roomChangedChannel || event.Channel == buildingChangedChannel {
+			c.clear()
+		}
+	}
+}
+
+// tileHandler serves Mapbox vector tiles of the buildings and rooms. Tiles may only be cached by shared
+// caches (public) if anonymous callers can read them too.
+type tileHandler struct {
+	db     *sqlx.DB
+	cache  *tileCache
+	maxAge time.Duration
+	public bool
+}
+
+func newTileHandler(db *sqlx.DB, hub *changeHub, config TilesConfig, anonymousRole string) *tileHandler {
+	cache := newTileCache(config.CacheSize)
+	events, _ := hub.subscribe()
+	go cache.clearOnChanges(events)
+	anonymous := &Identity{Roles: []string{anonymousRole}}
+	return &tileHandler{db, cache, config.MaxAge, anonymous.hasRole(roleReader)}
+}
+
+func (h *tileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, fmt.Sprintf("method %s is not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	if err := requireRole(r.Context(), roleReader); err != nil {
+		http.Error(w, err.Error(), err.(authError).status())
+		return
+	}
+
+	tile, err := parseTilePath(r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if levelParam := r.URL.Query().Get("level"); levelParam != "" {
+		level, err := strconv.Atoi(levelParam)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("level (%s) must be an integer", levelParam), http.StatusBadRequest)
+			return
+		}
+		tile.level = optionalIntFilter{true, level}
+	}
+
+	cached, hit := h.cache.get(tile.key())
+	if !hit {
+		generation := h.cache.currentGeneration()
+		mvt, err := getTile(r.Context(), h.db, tile)
+		if err != nil {
+			log.Printf("error generating tile %s: %v", tile.key(), err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		hash := sha256.Sum256(mvt)
+		cached = cachedTile{tile.key(), mvt, fmt.Sprintf("\"%s\"", hex.EncodeToString(hash[:16]))}
+		h.cache.put(cached, generation)
+	}
+
+	w.Header().Set("ETag", cached.etag)
+	visibility := "private"
+	if h.public {
+		visibility = "public"
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", visibility, int(h.maxAge.Seconds())))
+	if r.Header.Get("If-None-Match") == cached.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.mapbox-vector-tile")
+	w.Header().Set("Content-Length", strconv.Itoa(len(cached.mvt)))
+	w.Write(cached.mvt)
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of graphql operations and resolvers,
+// the http, sql and geocoder spans come from their instrumentation
+var tracer = otel.Tracer("github.com/ubipo/andin-api/internal/api")
+
+// setupTracing propagates W3C trace context and, if config has an endpoint, exports traces to it over OTLP/HTTP.
+// It returns a function that flushes the remaining spans.
+func setupTracing(ctx context.Context, config TracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if config.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(config.Endpoint)}
+	if config.Insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("error creating trace exporter: %v", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(config.ServiceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// tracingHandler starts a span (continuing the caller's trace, if any) for every request to h
+func tracingHandler(name string, h http.Handler) http.Handler {
+	return otelhttp.NewHandler(h, name)
+}
+
+type operationNameContextKey struct{}
+type executionSpanContextKey struct{}
+
+// tracingExtension traces the execution of operations and their resolvers. Resolver spans are children of
+// the execution span instead of their parent field's, as graphql-go passes the context of a field on to its
+// siblings. Fields without a resolver of their own aren't traced.
+type tracingExtension struct{}
+
+func (tracingExtension) Init(ctx context.Context, params *graphql.Params) context.Context {
+	return context.WithValue(ctx, operationNameContextKey{}, params.OperationName)
+}
+
+func (tracingExtension) Name() string {
+	return "tracing"
+}
+
+func (tracingExtension) ParseDidStart(ctx context.Context) (context.Context, graphql.ParseFinishFunc) {
+	return ctx, func(error) {}
+}
+
+func (tracingExtension) ValidationDidStart(ctx context.Context) (context.Context, graphql.ValidationFinishFunc) {
+	return ctx, func([]gqlerrors.FormattedError) {}
+}
+
+func (tracingExtension) ExecutionDidStart(ctx context.Context) (context.Context, graphql.ExecutionFinishFunc) {
+	operationName, _ := ctx.Value(operationNameContextKey{}).(string)
+	ctx, span := tracer.Start(ctx, "graphql.execute", trace.WithAttributes(attribute.String("graphql.operation.name", operationName)))
+	return context.WithValue(ctx, executionSpanContextKey{}, span), func(result *graphql.Result) {
+		if result.HasErrors() {
+			span.SetStatus(codes.Error, result.Errors[0].Message)
+		}
+		span.End()
+	}
+}
+
+func (tracingExtension) ResolveFieldDidStart(ctx context.Context, info *graphql.ResolveInfo) (context.Context, graphql.ResolveFieldFinishFunc) {
+	if !hasResolver(info) {
+		return ctx, func(interface{}, error) {}
+	}
+	if executionSpan, ok := ctx.Value(executionSpanContextKey{}).(trace.Span); ok {
+		ctx = trace.ContextWithSpan(ctx, executionSpan)
+	}
+	var path []string
+	for _, key := range info.Path.AsArray() {
+		path = append(path, fmt.Sprint(key))
+	}
+	ctx, span := tracer.Start(ctx, info.ParentType.Name()+"."+info.FieldName, trace.WithAttributes(attribute.String("graphql.field.path", strings.Join(path, "."))))
+	return ctx, func(_ interface{}, err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+func (tracingExtension) HasResult() bool {
+	return false
+}
+
+func (tracingExtension) GetResult(ctx context.Context) interface{} {
+	return nil
+}
+
+// hasResolver reports whether the field has a resolver of its own, rather than reading a value of its parent
+func hasResolver(info *graphql.ResolveInfo) bool {
+	if strings.HasPrefix(info.FieldName, "__") {
+		return false
+	}
+	object, isObject := info.ParentType.(*graphql.Object)
+	if !isObject {
+		return true
+	}
+	field, exists := object.Fields()[info.FieldName]
+	return exists && field.Resolve != nil
+}
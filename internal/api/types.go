@@ -5,11 +5,22 @@ type DistanceFrom struct {
 	Place       string
 	Min         float32
 	Max         float32
+	Unit        LengthUnit
 }
 
+// Area is a range of areas, without an upper bound if Max is nil
 type Area struct {
-	Min float32
-	Max float32
+	Min  float32
+	Max  *float32
+	Unit LengthUnit
+}
+
+// Within is a shape (a bounding box, GeoJSON or WKT geometry) to filter elements on
+type Within struct {
+	BBox     []float64
+	GeoJSON  string
+	WKT      string
+	Relation SpatialRelation
 }
 
 type Coordinates struct {
@@ -17,6 +28,14 @@ type Coordinates struct {
 	Lat float64
 }
 
+// BoundingBox is the extent of a geometry in longitude and latitude
+type BoundingBox struct {
+	MinLon float64 `json:"minLon" db:"min_lon"`
+	MinLat float64 `json:"minLat" db:"min_lat"`
+	MaxLon float64 `json:"maxLon" db:"max_lon"`
+	MaxLat float64 `json:"maxLat" db:"max_lat"`
+}
+
 // SortChoice defines how to sort rooms or buildings
 type SortChoice int
 
@@ -25,3 +44,12 @@ const (
 	SortDistance SortChoice = 0
 	SortArea     SortChoice = 1
 )
+
+// SpatialRelation defines how an element must relate to a Within shape
+type SpatialRelation int
+
+// SpatialRelation enum
+const (
+	RelationIntersects SpatialRelation = 0
+	RelationContains   SpatialRelation = 1
+)
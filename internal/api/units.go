@@ -0,0 +1,108 @@
+package api
+
+import (
+	"github.com/graphql-go/graphql"
+)
+
+// LengthUnit defines the unit lengths (and, squared, areas) are given in
+type LengthUnit int
+
+// LengthUnit enum
+const (
+	UnitMeters     LengthUnit = 0
+	UnitFeet       LengthUnit = 1
+	UnitKilometers LengthUnit = 2
+)
+
+// meters returns the length of one unit in meters
+func (unit LengthUnit) meters() float64 {
+	switch unit {
+	case UnitFeet:
+		return 0.3048
+	case UnitKilometers:
+		return 1000
+	}
+	return 1
+}
+
+// squareMeters returns the area of one square unit in square meters
+func (unit LengthUnit) squareMeters() float64 {
+	return unit.meters() * unit.meters()
+}
+
+var lengthUnitEnum = graphql.NewEnum(graphql.EnumConfig{
+	Name: "LengthUnit",
+	Values: graphql.EnumValueConfigMap{
+		"METERS": &graphql.EnumValueConfig{
+			Value: int(UnitMeters),
+		},
+		"FEET": &graphql.EnumValueConfig{
+			Value: int(UnitFeet),
+		},
+		"KILOMETERS": &graphql.EnumValueConfig{
+			Value: int(UnitKilometers),
+		},
+	},
+})
+
+// parseLengthUnitArg returns the unit of a unit argument or input field, meters if it is absent
+func parseLengthUnitArg(arg interface{}) LengthUnit {
+	if arg == nil {
+		return UnitMeters
+	}
+	return LengthUnit(arg.(int))
+}
+
+// gqlInUnit adds a unit argument to field, whose resolver returns meters (or square meters if squared),
+// and converts the value to that unit
+func gqlInUnit(squared bool, field *graphql.Field) *graphql.Field {
+	resolve := field.Resolve
+	if resolve == nil {
+		resolve = graphql.DefaultResolveFn
+	}
+	converted := *field
+	converted.Args = graphql.FieldConfigArgument{
+		"unit": &graphql.ArgumentConfig{
+			Type:         lengthUnitEnum,
+			DefaultValue: int(UnitMeters),
+		},
+	}
+	converted.Resolve = func(params graphql.ResolveParams) (interface{}, error) {
+		unit := parseLengthUnitArg(params.Args["unit"])
+		factor := unit.meters()
+		if squared {
+			factor = unit.squareMeters()
+		}
+		value, err := resolve(params)
+		if err != nil {
+			return nil, err
+		}
+		if thunk, isThunk := value.(func() (interface{}, error)); isThunk {
+			return func() (interface{}, error) {
+				value, err := thunk()
+				if err != nil {
+					return nil, err
+				}
+				return fromMeters(value, factor), nil
+			}, nil
+		}
+		return fromMeters(value, factor), nil
+	}
+	return &converted
+}
+
+// fromMeters divides a float (or float pointer) value by the size of a unit in meters
+func fromMeters(value interface{}, factor float64) interface{} {
+	switch value := value.(type) {
+	case float64:
+		return value / factor
+	case float32:
+		return float64(value) / factor
+	case *float64:
+		if value == nil {
+			return nil
+		}
+		return *value / factor
+	}
+	return value
+}